@@ -0,0 +1,416 @@
+package litedb
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// MySQL 单条预处理语句最多 65535 个占位符
+const maxPlaceholders = 65535
+
+// 无法检测 @@max_allowed_packet 时使用的默认值(MySQL 5.7 的默认设置)
+const defaultMaxAllowedPacket = 4 << 20
+
+// 批量操作的拆分设置.
+// BatchInsert/BatchReplace 的数据超过限制时会拆分为多条语句依次执行
+type BatchOptions struct {
+	// 每条语句的最大行数, 0 表示只受占位符数量的限制
+	MaxRows int
+
+	// 每条语句的最大字节数(按参数长度估算), 0 表示使用 @@max_allowed_packet.
+	// @@max_allowed_packet 在第一次拆分时查询并缓存
+	MaxBytes int
+
+	// BatchInsertOrUpdate 使用 MySQL 8.0.19 引入的行别名语法:
+	// INSERT ... VALUES (...) AS `new` ON DUPLICATE KEY UPDATE field=`new`.field
+	// 默认使用 VALUES(field), 兼容 MySQL 5.x
+	RowAlias bool
+
+	// 拆分为多条语句时在同一个事务中执行, 任意语句失败时全部回滚.
+	// 在 Transaction 上调用时使用保存点
+	Transaction bool
+}
+
+type batchConfig struct {
+	mu      sync.Mutex
+	options BatchOptions
+	packet  int // 检测到的 @@max_allowed_packet, 0 表示尚未检测
+
+	increment int64 // 检测到的 @@auto_increment_increment, 0 表示尚未检测
+}
+
+// 设置批量操作的拆分方式, 由该 Sql 开启的事务使用同样的设置
+func (this *Sql) SetBatchOptions(options BatchOptions) {
+
+	if this.batch == nil {
+		this.batch = new(batchConfig)
+	}
+
+	this.batch.mu.Lock()
+	this.batch.options = options
+	this.batch.mu.Unlock()
+}
+
+func (this *Sql) batchOptions() BatchOptions {
+
+	if this.batch == nil {
+		return BatchOptions{MaxBytes: defaultMaxAllowedPacket}
+	}
+
+	this.batch.mu.Lock()
+	options := this.batch.options
+	packet := this.batch.packet
+	this.batch.mu.Unlock()
+
+	if options.MaxBytes > 0 {
+		return options
+	}
+
+	// 检测在锁外进行, 失败时使用默认值但不缓存, 下一次批量操作会重新检测
+	if packet == 0 {
+		if detected, ok := this.maxAllowedPacket(); ok {
+			this.batch.mu.Lock()
+			this.batch.packet = detected
+			this.batch.mu.Unlock()
+			packet = detected
+		} else {
+			packet = defaultMaxAllowedPacket
+		}
+	}
+
+	options.MaxBytes = packet
+
+	return options
+}
+
+// 查询服务端变量使用的 Sql: 读写分离时为主库, 避免被路由到从库
+func (this *Sql) serverSql() *Sql {
+
+	if this.server != nil {
+		return this.server
+	}
+
+	return this
+}
+
+func (this *Sql) maxAllowedPacket() (int, bool) {
+
+	server := this.serverSql()
+
+	if server.Query == nil && server.QueryContext == nil {
+		return 0, false
+	}
+
+	packet, err := QueryScalar[int](server, "SELECT @@max_allowed_packet")
+
+	if err != nil || packet <= 0 {
+		if Debug {
+			log.Println("[Litedb Debug] detect max_allowed_packet error:", err)
+		}
+		return 0, false
+	}
+
+	return packet, true
+}
+
+// 多行 INSERT 中相邻两行自增值的差
+func (this *Sql) autoIncrement() int64 {
+
+	if this.batch == nil {
+		return 1
+	}
+
+	this.batch.mu.Lock()
+	increment := this.batch.increment
+	this.batch.mu.Unlock()
+
+	if increment > 0 {
+		return increment
+	}
+
+	// 与 max_allowed_packet 相同, 在主库上检测, 失败时不缓存
+	server := this.serverSql()
+
+	if server.Query == nil && server.QueryContext == nil {
+		return 1
+	}
+
+	increment, err := QueryScalar[int64](server, "SELECT @@auto_increment_increment")
+
+	if err != nil || increment <= 0 {
+		if Debug {
+			log.Println("[Litedb Debug] detect auto_increment_increment error:", err)
+		}
+		return 1
+	}
+
+	this.batch.mu.Lock()
+	this.batch.increment = increment
+	this.batch.mu.Unlock()
+
+	return increment
+}
+
+// 批量语句的类型
+type batchStmt struct {
+	verb     string   // INSERT, REPLACE 或 INSERT IGNORE
+	upsert   bool     // 追加 ON DUPLICATE KEY UPDATE
+	update   []string // 更新的字段, 为空时更新全部字段
+	rowAlias bool
+	autoIds  bool // 回填自增主键
+}
+
+type batchChunk struct {
+	table string
+	rows  []*rowValues
+}
+
+// 批量语句执行前后对每一行调用的钩子
+func (this batchStmt) hooks() ([]hookKind, []hookKind) {
+
+	if this.upsert {
+		return []hookKind{hookBeforeInsert, hookBeforeUpdate}, []hookKind{hookAfterInsert, hookAfterUpdate}
+	}
+
+	return []hookKind{hookBeforeInsert}, []hookKind{hookAfterInsert}
+}
+
+// 任意一行的 Before* 钩子返回错误时不执行语句; 全部语句执行成功后调用每一行的 After* 钩子
+func (this *Sql) batchExecContext(ctx context.Context, stmt batchStmt, table string, vs interface{}) *ClientExecResult {
+
+	before, after := stmt.hooks()
+
+	if err := runListHooks(this, vs, before...); err != nil {
+		return &ClientExecResult{Err: err}
+	}
+
+	r := this.batchExecRows(ctx, stmt, table, vs)
+
+	if r.Err == nil {
+		r.Err = runListHooks(this, vs, after...)
+	}
+
+	return r
+}
+
+func (this *Sql) batchExecRows(ctx context.Context, stmt batchStmt, table string, vs interface{}) *ClientExecResult {
+
+	r := new(ClientExecResult)
+
+	list, err := listStructToValues(vs)
+
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	// 只有全部行都由数据库生成 ID 时才能推算出每一行的 ID
+	for _, row := range list {
+		stmt.autoIds = stmt.autoIds && row.autoZero()
+	}
+
+	now := Clock()
+
+	for i, row := range list {
+		if list[i], err = row.forInsert(now); err != nil {
+			r.Err = err
+			return r
+		}
+	}
+
+	if stmt.upsert && len(updateColumns(unionColumns(list), list[0].meta, stmt.update)) < 1 {
+		r.Err = &SQLError{s: "nothing update"}
+		return r
+	}
+
+	tables, groups, err := this.routeRows(table, list)
+
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	options := this.batchOptions()
+	stmt.rowAlias = options.RowAlias
+	chunks := make([]batchChunk, 0, len(tables))
+
+	for i, table := range tables {
+		for _, rows := range chunkRows(table, groups[i], options) {
+			chunks = append(chunks, batchChunk{table: table, rows: rows})
+		}
+	}
+
+	if len(chunks) < 2 || !options.Transaction {
+		return this.execChunks(ctx, stmt, chunks)
+	}
+
+	tx, err := this.BeginTx(ctx, nil)
+
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	if r = tx.execChunks(ctx, stmt, chunks); r.Err != nil {
+		tx.Rollback()
+	} else {
+		r.Err = tx.Commit()
+	}
+
+	// 回滚后已回填的自增主键对应的行不存在, 恢复为零值, 重试时仍由数据库生成
+	if r.Err != nil && stmt.autoIds {
+		for _, chunk := range chunks {
+			for _, row := range chunk.rows {
+				row.setAutoId(0)
+			}
+		}
+	}
+
+	return r
+}
+
+// ON DUPLICATE KEY UPDATE 更新的字段.
+// 指定了 update 时按 update 的顺序挑选, 再加上 autoUpdateTime 字段;
+// 否则为 pk、auto 与软删除字段以外的全部字段. 两种情况都不更新 autoCreateTime 字段
+func updateColumns(columns []string, meta *structMeta, update []string) []string {
+
+	ret := make([]string, 0, len(columns))
+
+	field := func(k string) *fieldMeta {
+		if meta == nil {
+			return nil
+		}
+		return meta.columns[k]
+	}
+
+	if len(update) > 0 {
+
+		picked := make(map[string]bool, len(update))
+
+		for _, f := range update {
+			for _, k := range columns {
+				if k == f && !picked[k] && (field(k) == nil || !field(k).createTime) {
+					picked[k] = true
+					ret = append(ret, k)
+					break
+				}
+			}
+		}
+
+		for _, k := range columns {
+			if !picked[k] && field(k) != nil && field(k).updateTime {
+				ret = append(ret, k)
+			}
+		}
+
+		return ret
+	}
+
+	for _, k := range columns {
+		if f := field(k); f == nil || !f.skipUpdate() {
+			ret = append(ret, k)
+		}
+	}
+
+	return ret
+}
+
+// 依次执行每一批语句.
+// 只有一批时结果与直接执行相同, 多批时 Result 的 RowsAffected 为全部语句之和,
+// 失败时 Err 为 *BatchError, Result 为失败前已执行的语句
+func (this *Sql) execChunks(ctx context.Context, stmt batchStmt, chunks []batchChunk) *ClientExecResult {
+
+	results := make(multiResult, 0, len(chunks))
+
+	for i, chunk := range chunks {
+
+		sql, valList := batchSql(stmt, chunk.table, chunk.rows)
+
+		r := this.execContext(ctx, sql, valList...)
+
+		// 失败的语句没有可回填的主键, 也不需要检测自增步长
+		if stmt.autoIds && r.Err == nil {
+			fillAutoIds(r, chunk.rows, this.autoIncrement())
+		}
+
+		if len(chunks) == 1 {
+			return r
+		}
+
+		if r.Err != nil {
+			affected, _ := results.RowsAffected()
+			r.Err = &BatchError{Table: chunk.table, Chunk: i, Chunks: len(chunks), RowsAffected: affected, err: r.Err}
+			r.Result = results
+			return r
+		}
+
+		results = append(results, r.Result)
+	}
+
+	return &ClientExecResult{Result: results}
+}
+
+// 按行数、占位符数量与估算的字节数拆分.
+// 单行超过字节数限制时单独成为一批, 由数据库返回错误
+func chunkRows(table string, list []*rowValues, options BatchOptions) [][]*rowValues {
+
+	columns := unionColumns(list)
+
+	maxRows := maxPlaceholders
+	if len(columns) > 0 {
+		maxRows = maxPlaceholders / len(columns)
+	}
+
+	if options.MaxRows > 0 && options.MaxRows < maxRows {
+		maxRows = options.MaxRows
+	}
+
+	// 语句头部与网络包头预留的空间
+	header := len(table) + 1024
+	for _, k := range columns {
+		header += len(k) + 3
+	}
+
+	limit := options.MaxBytes - header
+
+	chunks := make([][]*rowValues, 0, 1)
+	start, size := 0, 0
+
+	for i, row := range list {
+
+		n := rowSize(row, columns)
+
+		if i > start && (i-start >= maxRows || size+n > limit) {
+			chunks = append(chunks, list[start:i])
+			start, size = i, 0
+		}
+
+		size += n
+	}
+
+	return append(chunks, list[start:])
+}
+
+// 估算一行数据占用的字节数: SQL 中的 "?," 以及参数的长度前缀与内容
+func rowSize(row *rowValues, columns []string) int {
+
+	n := 3
+
+	for _, k := range columns {
+
+		n += 11
+
+		switch v := row.values[k].(type) {
+		case string:
+			n += len(v)
+		case []byte:
+			n += len(v)
+		case nil:
+		default:
+			n += 8
+		}
+	}
+
+	return n
+}
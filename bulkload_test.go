@@ -0,0 +1,187 @@
+package litedb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type bulkRow struct {
+	Id   int64   `db:"id"`
+	Name *string `db:"name"`
+	Data []byte  `db:"data"`
+}
+
+func TestBulkLoadEncoding(t *testing.T) {
+
+	name := "a,b\\c\nd"
+	rows := make(chan bulkRow, 2)
+	rows <- bulkRow{Id: 1, Name: &name, Data: []byte{0, 'x', '\t'}}
+	rows <- bulkRow{Id: 2}
+	close(rows)
+
+	src, err := newBulkSource(rows, Clock())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _, _ := src.next()
+
+	var out bytes.Buffer
+
+	if err := writeBulkRows(&out, first.columns, first, src); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "1,a\\,b\\\\c\\nd,\\0x\\t\n2,\\N,\\N\n"
+
+	if out.String() != expect {
+		t.Fatalf("csv = %q, want %q", out.String(), expect)
+	}
+}
+
+func TestBulkLoadSql(t *testing.T) {
+
+	s, sqls, _ := recordSql()
+
+	r := s.BulkLoadWithOptions(context.Background(), "person", []sqlPerson{{Id: 1}, {Id: 2}}, BulkLoadOptions{
+		Columns:   []string{"id", "name"},
+		ColumnMap: map[string]string{"name": "nick"},
+		Replace:   true,
+	})
+
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+
+	got := (*sqls)[0]
+
+	if !strings.HasPrefix(got, "LOAD DATA LOCAL INFILE 'Reader::litedb_bulk_") ||
+		!strings.HasSuffix(got, "' REPLACE INTO TABLE `person` CHARACTER SET binary FIELDS TERMINATED BY ',' ESCAPED BY '\\\\' LINES TERMINATED BY '\\n' (`id`,`nick`)") {
+		t.Fatal("unexpected sql:", got)
+	}
+
+	if r := s.BulkLoad("person", 1); r.Err == nil {
+		t.Fatal("unsupported rows expected")
+	}
+}
+
+func TestBulkLoadInsertRules(t *testing.T) {
+
+	s, sqls, _ := recordSql()
+
+	if r := s.BulkLoad("person", []autoPerson{{Name: "a"}, {Name: "b"}}); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+
+	if got := (*sqls)[0]; !strings.HasSuffix(got, " INTO TABLE `person` CHARACTER SET binary FIELDS TERMINATED BY ',' ESCAPED BY '\\\\' LINES TERMINATED BY '\\n' (`name`)") {
+		t.Fatal("zero auto column should be left out:", got)
+	}
+
+	if r := s.BulkLoad("person", []autoPerson{{Name: "a"}, {Id: 2, Name: "b"}}); r.Err == nil {
+		t.Fatal("rows with different columns should fail")
+	}
+
+	s.SetTableRouter(personRouter(t))
+
+	if r := s.BulkLoad("person", []sqlPerson{{Id: 1}, {Id: 101}}); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+
+	if got := (*sqls)[len(*sqls)-1]; !strings.Contains(got, " INTO TABLE `person_01` ") {
+		t.Fatal("bulk load should use the routed table:", got)
+	}
+
+	if r := s.BulkLoad("person", []sqlPerson{{Id: 1}, {Id: 2}}); r.Err == nil {
+		t.Fatal("rows routed to different tables should fail")
+	}
+}
+
+var bulkReaders sync.Map
+
+// 与驱动一样读取 LOAD DATA 的数据, 读取的行数记录为 rows:n, 读取出错时返回该错误
+func (this *txDriver) readBulkData(query string) error {
+
+	i := strings.Index(query, "'Reader::")
+
+	if i < 0 {
+		return nil
+	}
+
+	name := query[i+len("'Reader::"):]
+	name = name[:strings.Index(name, "'")]
+
+	handler, ok := bulkReaders.Load(name)
+
+	if !ok {
+		return nil
+	}
+
+	data, err := io.ReadAll(handler.(func() io.Reader)())
+	this.record(fmt.Sprintf("rows:%d", bytes.Count(data, []byte("\n"))))
+
+	return err
+}
+
+func captureBulkReaders(t *testing.T) {
+
+	registerReaderHandler = func(name string, handler func() io.Reader) { bulkReaders.Store(name, handler) }
+	deregisterReaderHandler = func(name string) { bulkReaders.Delete(name) }
+
+	t.Cleanup(func() {
+		registerReaderHandler = mysql.RegisterReaderHandler
+		deregisterReaderHandler = mysql.DeregisterReaderHandler
+	})
+}
+
+func TestBulkLoadInvalidRows(t *testing.T) {
+
+	captureBulkReaders(t)
+
+	client := newTxClient(t)
+	client.SetTableRouter(personRouter(t))
+
+	if r := client.BulkLoad("person", []sqlPerson{{Id: 1}, {Id: 2}}); r.Err == nil {
+		t.Fatal("rows routed to different tables should fail")
+	}
+
+	if events := testTxDriver.reset(); len(events) != 0 {
+		t.Fatal("invalid slice should not be sent:", events)
+	}
+
+	stream := func(ids ...int64) chan sqlPerson {
+		rows := make(chan sqlPerson, len(ids))
+		for _, id := range ids {
+			rows <- sqlPerson{Id: id}
+		}
+		close(rows)
+		return rows
+	}
+
+	if r := client.BulkLoad("person", stream(1, 101, 2)); r.Err == nil {
+		t.Fatal("rows routed to different tables should fail")
+	}
+
+	events := testTxDriver.reset()
+
+	if len(events) != 4 || events[0] != "begin" || !strings.HasPrefix(events[1], "LOAD DATA") || events[3] != "rollback" {
+		t.Fatal("invalid channel should be rolled back:", events)
+	}
+
+	if r := client.BulkLoad("person", stream(1, 101)); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+
+	events = testTxDriver.reset()
+
+	if len(events) != 4 || events[0] != "begin" || events[2] != "rows:2" || events[3] != "commit" {
+		t.Fatal("channel should be loaded in a transaction:", events)
+	}
+}
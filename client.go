@@ -8,12 +8,15 @@ package litedb
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log"
+	"reflect"
+	"strconv"
 
 	"time"
 
@@ -44,8 +47,30 @@ func CloseDebug() {
 
 // Sql操作集
 type Sql struct {
-	Exec  func(sqlFmt string, sqlValue ...interface{}) *ClientExecResult
-	Query func(sqlFmt string, sqlValue ...interface{}) *ClientQueryResult
+	Exec         func(sqlFmt string, sqlValue ...interface{}) *ClientExecResult
+	Query        func(sqlFmt string, sqlValue ...interface{}) *ClientQueryResult
+	ExecContext  func(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientExecResult
+	QueryContext func(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientQueryResult
+
+	// 由 Client 与 Transaction 设置, 见 Sql.Begin
+	beginTx func(ctx context.Context, opts *sql.TxOptions) (*Transaction, error)
+
+	// 分表路由, 见 Sql.SetTableRouter 与 Sql.Route
+	router     TableRouter
+	routed     bool
+	routeValue interface{}
+
+	// 批量操作的拆分设置, 见 Sql.SetBatchOptions
+	batch *batchConfig
+
+	// 查询 @@max_allowed_packet 等服务端变量使用的 Sql, 为 nil 时使用自身
+	server *Sql
+
+	// 软删除的表, 见 Sql.SetSoftDelete
+	softDelete *softDeleteConfig
+
+	// 拦截器, 见 Sql.Use
+	interceptors []Interceptor
 }
 
 // 客户端
@@ -65,6 +90,8 @@ type Client struct {
 	maxIdleConn     int
 	maxConn         int
 	connMaxLifetime time.Duration
+
+	txRetry TxRetryPolicy
 }
 
 // 事务客户端
@@ -72,13 +99,30 @@ type Transaction struct {
 	Sql
 	tx *sql.Tx
 	db *sql.DB
+
+	parent    *Transaction    // 嵌套事务的外层事务, 最外层为 nil
+	savepoint string          // 嵌套事务对应的保存点
+	ctx       context.Context // 开启事务时的 ctx, 传递给 commit/rollback 的拦截器
+	seq       *int            // 同一个事务中保存点名称的序号
+	options   TxOptions
+	done      bool
 }
 
 // 对Struct类型的支持,使用 db tag 进行数据库字段映射
 // 对Map类型会将value转换为string.请确保map类型中只包含基本数据类型
+// 使用 db:"id,pk,auto" 标记自增主键时, 零值的主键不会写入, 插入后生成的 ID 会回填到 v(需要传入指针)
 func (this *Sql) Insert(table string, v interface{}) *ClientExecResult {
+	return this.InsertContext(context.Background(), table, v)
+}
+
+// InsertContext 同 Insert, 使用 ctx 控制语句的取消与超时
+func (this *Sql) InsertContext(ctx context.Context, table string, v interface{}) *ClientExecResult {
+
+	if err := runHooks(this, v, hookBeforeInsert); err != nil {
+		return &ClientExecResult{Err: err}
+	}
 
-	smap, err := StructToMap(v)
+	smap, err := structToValues(v)
 	r := new(ClientExecResult)
 
 	if err != nil {
@@ -87,17 +131,28 @@ func (this *Sql) Insert(table string, v interface{}) *ClientExecResult {
 		return r
 	}
 
-	if len(smap) < 1 {
+	if smap.Len() < 1 {
 		r.Err = &SQLError{s: "nothing insert"}
 		return r
 	}
 
+	if table, err = this.routeTable(table, smap); err != nil {
+		r.Err = err
+		return r
+	}
+
+	if smap, err = smap.forInsert(Clock()); err != nil {
+		r.Err = err
+		return r
+	}
+
 	keys := bytes.NewBufferString("")
 	vals := bytes.NewBufferString("")
 
 	valList := make([]interface{}, 0)
 
-	for k, v := range smap {
+	for _, k := range smap.columns {
+		v := smap.values[k]
 		keys.WriteString(fmt.Sprintf("`%s`,", k))
 		vals.WriteString("?,")
 		valList = append(valList, v)
@@ -106,15 +161,28 @@ func (this *Sql) Insert(table string, v interface{}) *ClientExecResult {
 	keysSplit := string(keys.Bytes()[0 : keys.Len()-1])
 	valsSplit := string(vals.Bytes()[0 : vals.Len()-1])
 	sql := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s);", table, keysSplit, valsSplit)
-	return this.Exec(sql, valList...)
+
+	r = this.execContext(ctx, sql, valList...)
+	fillAutoIds(r, []*rowValues{smap}, 1)
+
+	return this.afterHooks(r, v, hookAfterInsert)
 }
 
 // 对Struct类型的支持,使用 db tag 进行数据库字段映射
 // 对Map类型会将value转换为string.请确保map类型中只包含基本数据类型
 // where 条件写法 id = ?
 func (this *Sql) Update(table string, v interface{}, whereFmt string, whereValue ...interface{}) *ClientExecResult {
+	return this.UpdateContext(context.Background(), table, v, whereFmt, whereValue...)
+}
+
+// UpdateContext 同 Update, 使用 ctx 控制语句的取消与超时
+func (this *Sql) UpdateContext(ctx context.Context, table string, v interface{}, whereFmt string, whereValue ...interface{}) *ClientExecResult {
 
-	smap, err := StructToMap(v)
+	if err := runHooks(this, v, hookBeforeUpdate); err != nil {
+		return &ClientExecResult{Err: err}
+	}
+
+	smap, err := structToValues(v)
 	r := new(ClientExecResult)
 
 	if err != nil {
@@ -122,7 +190,22 @@ func (this *Sql) Update(table string, v interface{}, whereFmt string, whereValue
 		return r
 	}
 
-	if len(smap) < 1 {
+	if smap.Len() < 1 {
+		r.Err = &SQLError{s: "nothing update"}
+		return r
+	}
+
+	if table, err = this.routeTable(table, smap); err != nil {
+		r.Err = err
+		return r
+	}
+
+	if smap, err = smap.forUpdate(Clock()); err != nil {
+		r.Err = err
+		return r
+	}
+
+	if smap.Len() < 1 {
 		r.Err = &SQLError{s: "nothing update"}
 		return r
 	}
@@ -130,14 +213,15 @@ func (this *Sql) Update(table string, v interface{}, whereFmt string, whereValue
 	set := bytes.NewBufferString("")
 	valList := make([]interface{}, 0)
 
-	for k, v := range smap {
+	for _, k := range smap.columns {
+		v := smap.values[k]
 		set.WriteString(fmt.Sprintf("`%s`=?,", k))
 		valList = append(valList, v)
 	}
 	setSplit := string(set.Bytes()[0 : set.Len()-1])
 	sql := fmt.Sprintf("UPDATE `%s` SET %s WHERE %s", table, setSplit, whereFmt)
 	valList = append(valList, whereValue...)
-	return this.Exec(sql, valList...)
+	return this.afterHooks(this.execContext(ctx, sql, valList...), v, hookAfterUpdate)
 
 }
 
@@ -149,8 +233,17 @@ func (this *Sql) Update(table string, v interface{}, whereFmt string, whereValue
 // fields 就是需要更新的数据库字段名称
 // v,whereFmt,WhereValue 等值意义不变
 func (this *Sql) UpdateFields(table string, v interface{}, fields []string, whereFmt string, whereValue ...interface{}) *ClientExecResult {
+	return this.UpdateFieldsContext(context.Background(), table, v, fields, whereFmt, whereValue...)
+}
+
+// UpdateFieldsContext 同 UpdateFields, 使用 ctx 控制语句的取消与超时
+func (this *Sql) UpdateFieldsContext(ctx context.Context, table string, v interface{}, fields []string, whereFmt string, whereValue ...interface{}) *ClientExecResult {
 
-	smap, err := StructToMap(v)
+	if err := runHooks(this, v, hookBeforeUpdate); err != nil {
+		return &ClientExecResult{Err: err}
+	}
+
+	smap, err := structToValues(v)
 	r := new(ClientExecResult)
 
 	if err != nil {
@@ -158,26 +251,26 @@ func (this *Sql) UpdateFields(table string, v interface{}, fields []string, wher
 		return r
 	}
 
-	if len(smap) < 1 {
+	if smap.Len() < 1 {
 		r.Err = &SQLError{s: "nothing update"}
 		return r
 	}
 
-	vmap := make(map[string]string, 0)
-
-	for _, f := range fields {
-		v, ok := smap[f]
-		if ok {
-			vmap[f] = v
-		}
+	if table, err = this.routeTable(table, smap); err != nil {
+		r.Err = err
+		return r
 	}
 
-	smap = vmap
+	if smap, err = smap.pick(fields).touch(Clock()); err != nil {
+		r.Err = err
+		return r
+	}
 
 	set := bytes.NewBufferString("")
 	valList := make([]interface{}, 0)
 
-	for k, v := range smap {
+	for _, k := range smap.columns {
+		v := smap.values[k]
 		set.WriteString(fmt.Sprintf("`%s`=?,", k))
 		valList = append(valList, v)
 	}
@@ -185,22 +278,131 @@ func (this *Sql) UpdateFields(table string, v interface{}, fields []string, wher
 	setSplit := string(set.Bytes()[0 : set.Len()-1])
 
 	sql := fmt.Sprintf("UPDATE `%s` SET %s WHERE %s", table, setSplit, whereFmt)
-	return this.Exec(sql, append(valList, whereValue...)...)
+	return this.afterHooks(this.execContext(ctx, sql, append(valList, whereValue...)...), v, hookAfterUpdate)
+
+}
 
+// 基于版本号的乐观锁更新.
+// v 必须是 struct 指针, 并且包含 db:"version,version" 标记的整数字段.
+// SQL语句为: UPDATE `table` SET ...,`version`=`version`+1 WHERE (whereFmt) AND `version`=?
+// 更新成功后 v 的版本号加一; 没有更新任何行时返回 StaleObjectError,
+// 说明数据已被其他人修改或已被删除. 由于版本号总会改变, 该判断与 clientFoundRows 的设置无关
+func (this *Sql) UpdateWithVersion(table string, v interface{}, whereFmt string, whereValue ...interface{}) *ClientExecResult {
+	return this.UpdateWithVersionContext(context.Background(), table, v, whereFmt, whereValue...)
 }
 
-// 根据Where条件删除数据
+// UpdateWithVersionContext 同 UpdateWithVersion, 使用 ctx 控制语句的取消与超时
+func (this *Sql) UpdateWithVersionContext(ctx context.Context, table string, v interface{}, whereFmt string, whereValue ...interface{}) *ClientExecResult {
+
+	if err := runHooks(this, v, hookBeforeUpdate); err != nil {
+		return &ClientExecResult{Err: err}
+	}
+
+	smap, err := structToValues(v)
+	r := new(ClientExecResult)
+
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	if !smap.src.IsValid() || smap.meta.version == nil {
+		r.Err = &ReflectError{s: "UpdateWithVersion need a struct pointer with version field"}
+		return r
+	}
+
+	field := smap.meta.version
+	fv, _ := fieldByIndex(smap.src, field.index)
+
+	var version int64
+
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		version = fv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		version = int64(fv.Uint())
+	default:
+		r.Err = &ReflectError{s: "version field must be integer:" + fv.Type().String()}
+		return r
+	}
+
+	if table, err = this.routeTable(table, smap); err != nil {
+		r.Err = err
+		return r
+	}
+
+	if smap, err = smap.forUpdate(Clock()); err != nil {
+		r.Err = err
+		return r
+	}
+
+	set := bytes.NewBufferString("")
+	valList := make([]interface{}, 0)
+
+	for _, k := range smap.columns {
+		if k != field.column {
+			set.WriteString(fmt.Sprintf("`%s`=?,", k))
+			valList = append(valList, smap.values[k])
+		}
+	}
+
+	set.WriteString(fmt.Sprintf("`%s`=`%s`+1", field.column, field.column))
+
+	sql := fmt.Sprintf("UPDATE `%s` SET %s WHERE (%s) AND `%s`=?", table, set.String(), whereFmt, field.column)
+	valList = append(append(valList, whereValue...), version)
+
+	if r = this.execContext(ctx, sql, valList...); r.Err != nil {
+		return r
+	}
+
+	if n, err := r.Result.RowsAffected(); err != nil {
+		r.Err = wrapError(err)
+		return r
+	} else if n < 1 {
+		r.Err = &StaleObjectError{Table: table, Version: version}
+		return r
+	}
+
+	raw := strconv.FormatInt(version+1, 10)
+
+	if r.Err = field.decode(fv, &raw); r.Err != nil {
+		return r
+	}
+
+	return this.afterHooks(r, v, hookAfterUpdate)
+}
+
+// 根据Where条件删除数据.
+// table 通过 SetSoftDelete/SetSoftDeleteModel 注册了软删除时只标记删除字段, 物理删除请使用 HardDelete
 func (this *Sql) Delete(table string, whereFmt string, whereValue ...interface{}) *ClientExecResult {
-	sql := fmt.Sprintf("DELETE FROM `%s` WHERE %s", table, whereFmt)
-	return this.Exec(sql, whereValue...)
+	return this.DeleteContext(context.Background(), table, whereFmt, whereValue...)
+}
+
+// DeleteContext 同 Delete, 使用 ctx 控制语句的取消与超时
+func (this *Sql) DeleteContext(ctx context.Context, table string, whereFmt string, whereValue ...interface{}) *ClientExecResult {
+
+	if sd, ok := this.softDeleteOf(table); ok {
+		return this.softDeleteContext(ctx, sd, table, whereFmt, whereValue...)
+	}
+
+	return this.HardDeleteContext(ctx, table, whereFmt, whereValue...)
 }
 
 // 插入或更新行(当主键已存在的时候)
 // SQL语句为: INSERT INTO .... ON DUPLICATE KEY UPDATE ....
-// 全部字段更新
+// 全部字段更新. 依次调用 v 的 Insert 与 Update 钩子
 func (this *Sql) InsertOrUpdate(table string, v interface{}) *ClientExecResult {
+	return this.InsertOrUpdateContext(context.Background(), table, v)
+}
 
-	smap, err := StructToMap(v)
+// InsertOrUpdateContext 同 InsertOrUpdate, 使用 ctx 控制语句的取消与超时
+func (this *Sql) InsertOrUpdateContext(ctx context.Context, table string, v interface{}) *ClientExecResult {
+
+	if err := runHooks(this, v, hookBeforeInsert, hookBeforeUpdate); err != nil {
+		return &ClientExecResult{Err: err}
+	}
+
+	smap, err := structToValues(v)
 	r := new(ClientExecResult)
 
 	if err != nil {
@@ -208,12 +410,35 @@ func (this *Sql) InsertOrUpdate(table string, v interface{}) *ClientExecResult {
 		return r
 	}
 
-	if len(smap) < 1 {
+	if smap.Len() < 1 {
 		r.Err = &SQLError{s: "nothing insert"}
 		return r
 
 	}
 
+	if table, err = this.routeTable(table, smap); err != nil {
+		r.Err = err
+		return r
+	}
+
+	now := Clock()
+	updateMap, err := smap.forUpdate(now)
+
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	if updateMap.Len() < 1 {
+		r.Err = &SQLError{s: "nothing update"}
+		return r
+	}
+
+	if smap, err = smap.forInsert(now); err != nil {
+		r.Err = err
+		return r
+	}
+
 	insertKeys := bytes.NewBufferString("")
 	insertVals := bytes.NewBufferString("")
 
@@ -222,12 +447,16 @@ func (this *Sql) InsertOrUpdate(table string, v interface{}) *ClientExecResult {
 	insertValList := make([]interface{}, 0)
 	updateValList := make([]interface{}, 0)
 
-	for k, v := range smap {
+	for _, k := range smap.columns {
+		v := smap.values[k]
 		insertKeys.WriteString(fmt.Sprintf("`%s`,", k))
 		insertVals.WriteString("?,")
+		insertValList = append(insertValList, v)
+	}
 
+	for _, k := range updateMap.columns {
+		v := updateMap.values[k]
 		set.WriteString(fmt.Sprintf("`%s`=?,", k))
-		insertValList = append(insertValList, v)
 		updateValList = append(updateValList, v)
 	}
 
@@ -239,17 +468,26 @@ func (this *Sql) InsertOrUpdate(table string, v interface{}) *ClientExecResult {
 
 	sql := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s) ON DUPLICATE KEY UPDATE  %s", table, keysSplit, valsSplit, setSplit)
 
-	return this.Exec(sql, insertValList...)
+	return this.afterHooks(this.execContext(ctx, sql, insertValList...), v, hookAfterInsert, hookAfterUpdate)
 
 }
 
 // map类型无必要使用该方法
 // 插入或更新行(当主键已存在的时候)
 // SQL语句为: INSERT INTO .... ON DUPLICATE KEY UPDATE ....
-// 可以指定更新字段
+// 可以指定更新字段, 钩子的调用同 InsertOrUpdate
 func (this *Sql) InsertOrUpdateFields(table string, v interface{}, updateFields ...string) *ClientExecResult {
+	return this.InsertOrUpdateFieldsContext(context.Background(), table, v, updateFields...)
+}
+
+// InsertOrUpdateFieldsContext 同 InsertOrUpdateFields, 使用 ctx 控制语句的取消与超时
+func (this *Sql) InsertOrUpdateFieldsContext(ctx context.Context, table string, v interface{}, updateFields ...string) *ClientExecResult {
+
+	if err := runHooks(this, v, hookBeforeInsert, hookBeforeUpdate); err != nil {
+		return &ClientExecResult{Err: err}
+	}
 
-	smap, err := StructToMap(v)
+	smap, err := structToValues(v)
 	r := new(ClientExecResult)
 
 	if err != nil {
@@ -257,28 +495,41 @@ func (this *Sql) InsertOrUpdateFields(table string, v interface{}, updateFields
 		return r
 	}
 
-	if len(smap) < 1 {
+	if smap.Len() < 1 {
 		r.Err = &SQLError{s: "nothing insert"}
 		return r
 	}
 
+	if table, err = this.routeTable(table, smap); err != nil {
+		r.Err = err
+		return r
+	}
+
 	if len(updateFields) < 1 {
 		r.Err = &SQLError{s: "nothing update"}
 		return r
 	}
 
-	updateMap := make(map[string]string, 0)
-	for _, f := range updateFields {
-		if v, ok := smap[f]; ok {
-			updateMap[f] = v
-		}
+	now := Clock()
+
+	// 更新时不覆盖 autoCreateTime 字段
+	updateMap, err := smap.pick(smap.withoutCreateTime(updateFields)).touch(now)
+
+	if err != nil {
+		r.Err = err
+		return r
 	}
 
-	if len(updateMap) < 1 {
+	if updateMap.Len() < 1 {
 		r.Err = &SQLError{s: "nothing update"}
 		return r
 	}
 
+	if smap, err = smap.forInsert(now); err != nil {
+		r.Err = err
+		return r
+	}
+
 	insertKeys := bytes.NewBufferString("")
 	insertVals := bytes.NewBufferString("")
 
@@ -287,14 +538,16 @@ func (this *Sql) InsertOrUpdateFields(table string, v interface{}, updateFields
 	insertValList := make([]interface{}, 0)
 	updateValList := make([]interface{}, 0)
 
-	for k, v := range smap {
+	for _, k := range smap.columns {
+		v := smap.values[k]
 		insertKeys.WriteString(fmt.Sprintf("`%s`,", k))
 		insertVals.WriteString("?,")
 		insertValList = append(insertValList, v)
 
 	}
 
-	for k, v := range updateMap {
+	for _, k := range updateMap.columns {
+		v := updateMap.values[k]
 		set.WriteString(fmt.Sprintf("`%s`=?,", k))
 		updateValList = append(updateValList, v)
 	}
@@ -307,32 +560,72 @@ func (this *Sql) InsertOrUpdateFields(table string, v interface{}, updateFields
 
 	sql := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s) ON DUPLICATE KEY UPDATE  %s", table, keysSplit, valsSplit, setSplit)
 
-	return this.Exec(sql, insertValList...)
+	return this.afterHooks(this.execContext(ctx, sql, insertValList...), v, hookAfterInsert, hookAfterUpdate)
 
 }
 
 // 批量插入
 // SQL语句为: INSERT INTO `%s` (field,field) VALUES (?,?),(?,?)
+// 全部行的自增主键都为零值时, 插入后按 LastInsertId 与 auto_increment_increment 回填生成的 ID,
+// vs 需要是 slice 或指向 slice/array 的指针. 依赖 MySQL 为单条多行 INSERT 分配连续的自增值
 func (this *Sql) BatchInsert(table string, vs interface{}) *ClientExecResult {
+	return this.BatchInsertContext(context.Background(), table, vs)
+}
 
-	r := new(ClientExecResult)
+// BatchInsertContext 同 BatchInsert, 使用 ctx 控制语句的取消与超时.
+// 设置了分表路由时, 数据按物理表分组, 每张表执行一条语句.
+// 数据超过 BatchOptions 的限制时会拆分为多条语句执行, 见 Sql.SetBatchOptions
+func (this *Sql) BatchInsertContext(ctx context.Context, table string, vs interface{}) *ClientExecResult {
+	return this.batchExecContext(ctx, batchStmt{verb: "INSERT", autoIds: true}, table, vs)
+}
+
+// 批量重置
+// SQL语句为: REPLACE INTO `%s` (field,field) VALUES (?,?),(?,?)
+func (this *Sql) BatchReplace(table string, vs interface{}) *ClientExecResult {
+	return this.BatchReplaceContext(context.Background(), table, vs)
+}
 
-	list, err := ListStructToMap(vs)
+// BatchReplaceContext 同 BatchReplace, 使用 ctx 控制语句的取消与超时
+func (this *Sql) BatchReplaceContext(ctx context.Context, table string, vs interface{}) *ClientExecResult {
+	return this.batchExecContext(ctx, batchStmt{verb: "REPLACE"}, table, vs)
+}
+
+// 批量插入或更新行(当主键或唯一键已存在的时候)
+// SQL语句为: INSERT INTO `%s` (field,field) VALUES (?,?),(?,?) ON DUPLICATE KEY UPDATE field=VALUES(field)
+// updateFields 为需要更新的字段, 为空时更新全部字段.
+// 与 BatchReplace 不同, 已存在的行不会被删除后重新插入.
+// 设置 BatchOptions.RowAlias 后使用 MySQL 8.0.19 的行别名语法代替已废弃的 VALUES()
+func (this *Sql) BatchInsertOrUpdate(table string, vs interface{}, updateFields ...string) *ClientExecResult {
+	return this.BatchInsertOrUpdateContext(context.Background(), table, vs, updateFields...)
+}
+
+// BatchInsertOrUpdateContext 同 BatchInsertOrUpdate, 使用 ctx 控制语句的取消与超时
+func (this *Sql) BatchInsertOrUpdateContext(ctx context.Context, table string, vs interface{}, updateFields ...string) *ClientExecResult {
+	return this.batchExecContext(ctx, batchStmt{verb: "INSERT", upsert: true, update: updateFields}, table, vs)
+}
+
+// 批量插入, 忽略主键或唯一键冲突的行
+// SQL语句为: INSERT IGNORE INTO `%s` (field,field) VALUES (?,?),(?,?)
+func (this *Sql) BatchInsertIgnore(table string, vs interface{}) *ClientExecResult {
+	return this.BatchInsertIgnoreContext(context.Background(), table, vs)
+}
+
+// BatchInsertIgnoreContext 同 BatchInsertIgnore, 使用 ctx 控制语句的取消与超时
+func (this *Sql) BatchInsertIgnoreContext(ctx context.Context, table string, vs interface{}) *ClientExecResult {
+	return this.batchExecContext(ctx, batchStmt{verb: "INSERT IGNORE"}, table, vs)
+}
+
+// SQL语句为: INSERT INTO `%s` (field,field) VALUES (?,?),(?,?)
+// 字段以第一行为准
+func batchSql(stmt batchStmt, table string, list []*rowValues) (string, []interface{}) {
 
-	if err != nil {
-		r.Err = err
-		return r
-	}
 	valList := make([]interface{}, 0)
-	sql := fmt.Sprintf("INSERT INTO `%s` ", table)
+	sql := fmt.Sprintf("%s INTO `%s` ", stmt.verb, table)
 
-	smap := list[0]
+	keysIndex := unionColumns(list)
 	keys := bytes.NewBufferString("")
 
-	keysIndex := []string{}
-
-	for k, _ := range smap {
-		keysIndex = append(keysIndex, k)
+	for _, k := range keysIndex {
 		keys.WriteString(fmt.Sprintf("`%s`,", k))
 	}
 	keysSplit := string(keys.Bytes()[0 : keys.Len()-1])
@@ -344,9 +637,13 @@ func (this *Sql) BatchInsert(table string, vs interface{}) *ClientExecResult {
 
 		for i := 0; i < len(keysIndex); i++ {
 			k := keysIndex[i]
-			v := smap[k]
-			vals.WriteString("?,")
-			valList = append(valList, v)
+			// 该行没有的字段(omitempty 等)使用数据库的默认值
+			if v, ok := smap.values[k]; ok {
+				vals.WriteString("?,")
+				valList = append(valList, v)
+			} else {
+				vals.WriteString("DEFAULT,")
+			}
 		}
 
 		valsSplit := string(vals.Bytes()[0 : vals.Len()-1])
@@ -355,56 +652,78 @@ func (this *Sql) BatchInsert(table string, vs interface{}) *ClientExecResult {
 
 	sql = string([]byte(sql)[0 : len(sql)-1])
 
-	return this.Exec(sql, valList...)
+	if stmt.upsert {
+
+		set := bytes.NewBufferString("")
+
+		for _, k := range updateColumns(keysIndex, list[0].meta, stmt.update) {
+			if stmt.rowAlias {
+				set.WriteString(fmt.Sprintf("`%s`=`new`.`%s`,", k, k))
+			} else {
+				set.WriteString(fmt.Sprintf("`%s`=VALUES(`%s`),", k, k))
+			}
+		}
+
+		if stmt.rowAlias {
+			sql += " AS `new`"
+		}
+
+		sql += " ON DUPLICATE KEY UPDATE " + string(set.Bytes()[0:set.Len()-1])
+	}
 
+	return sql, valList
 }
 
-// 批量重置
-// SQL语句为: REPLACE INTO `%s` (field,field) VALUES (?,?),(?,?)
-func (this *Sql) BatchReplace(table string, vs interface{}) *ClientExecResult {
+// 插入成功后按 LastInsertId 回填自增主键.
+// 多行 INSERT 的 LastInsertId 为第一行的 ID, 之后的行依次增加 increment(auto_increment_increment)
+func fillAutoIds(r *ClientExecResult, rows []*rowValues, increment int64) {
 
-	r := new(ClientExecResult)
+	if r.Err != nil || r.Result == nil || !rows[0].autoZero() {
+		return
+	}
 
-	list, err := ListStructToMap(vs)
+	first, err := r.Result.LastInsertId()
 
 	if err != nil {
-		r.Err = err
-		return r
+		return
 	}
-	valList := make([]interface{}, 0)
-	sql := fmt.Sprintf("REPLACE INTO `%s` ", table)
 
-	smap := list[0]
-	keys := bytes.NewBufferString("")
+	for i, row := range rows {
+		if err := row.setAutoId(first + int64(i)*increment); err != nil {
+			r.Err = err
+			return
+		}
+	}
+}
 
-	keysIndex := []string{}
+// 语法糖统一通过该方法执行.
+// 未设置 ExecContext 时(例如自行组装的 Sql)只有不可取消的 ctx 可以退回到 Exec,
+// 否则返回错误, 不会静默地忽略 ctx
+func (this *Sql) execContext(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientExecResult {
 
-	for k, _ := range smap {
-		keysIndex = append(keysIndex, k)
-		keys.WriteString(fmt.Sprintf("`%s`,", k))
+	if this.ExecContext != nil {
+		return this.ExecContext(ctx, sqlFmt, sqlValue...)
 	}
-	keysSplit := string(keys.Bytes()[0 : keys.Len()-1])
 
-	sql += fmt.Sprintf("(%s) VALUES ", keysSplit)
+	if ctx != nil && ctx.Done() != nil {
+		return &ClientExecResult{Err: &SQLError{s: "ExecContext is not set, can not execute with a cancelable context"}}
+	}
 
-	for _, smap := range list {
-		vals := bytes.NewBufferString("")
+	return this.Exec(sqlFmt, sqlValue...)
+}
 
-		for i := 0; i < len(keysIndex); i++ {
-			k := keysIndex[i]
-			v := smap[k]
-			vals.WriteString("?,")
-			valList = append(valList, v)
-		}
+// 同 execContext, 用于查询
+func (this *Sql) queryWithContext(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientQueryResult {
 
-		valsSplit := string(vals.Bytes()[0 : vals.Len()-1])
-		sql += fmt.Sprintf("(%s),", valsSplit)
+	if this.QueryContext != nil {
+		return this.QueryContext(ctx, sqlFmt, sqlValue...)
 	}
 
-	sql = string([]byte(sql)[0 : len(sql)-1])
-
-	return this.Exec(sql, valList...)
+	if ctx != nil && ctx.Done() != nil {
+		return &ClientQueryResult{Err: &SQLError{s: "QueryContext is not set, can not query with a cancelable context"}, db: this}
+	}
 
+	return this.Query(sqlFmt, sqlValue...)
 }
 
 // =======================================================================================================
@@ -430,17 +749,26 @@ func NewClient(protocol string, host string, port uint32, user string, password
 	client.maxConn = 0
 	client.maxIdleConn = 0
 
-	client.Exec = client.exec
-	client.Query = client.query
+	client.init()
 
 	if err := client.connect(); err != nil {
-		log.Println(&NetError{s: "connect error:" + err.Error()})
+		log.Println(&NetError{s: "connect error:" + err.Error(), err: err})
 		return nil, err
 	}
 
 	return client, nil
 }
 
+func (this *Client) init() {
+	this.Exec = this.exec
+	this.Query = this.query
+	this.ExecContext = this.execContext
+	this.QueryContext = this.queryContext
+	this.beginTx = this.BeginTx
+	this.batch = new(batchConfig)
+	this.softDelete = new(softDeleteConfig)
+}
+
 // 初始化一个TCP客户端
 func NewTcpClient(host string, port uint32, user string, password string, database string, ssl bool, rootCertData []byte) (*Client, error) {
 	return NewClient("tcp", host, port, user, password, database, ssl, rootCertData)
@@ -455,24 +783,32 @@ func NewTcpClient(host string, port uint32, user string, password string, databa
 // 支持完整的SQL语句与?占位符.对于?占位符的使用请参考官方文档
 // ?占位符是字符串安全的,请尽量使用?占位符
 func (this *Client) exec(sqlFmt string, sqlValue ...interface{}) *ClientExecResult {
+	return this.execContext(context.Background(), sqlFmt, sqlValue...)
+}
+
+func (this *Client) execContext(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientExecResult {
+	return this.interceptExec(ctx, &QueryInfo{Op: OpExec, SQL: sqlFmt, Args: sqlValue}, this.execDB)
+}
+
+func (this *Client) execDB(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientExecResult {
 
 	result := new(ClientExecResult)
 
 	if err := this.connect(); err != nil {
-		result.Err = err
+		result.Err = wrapError(err)
 		return result
 	}
 
 	var ret sql.Result
 	var err error
 
-	ret, err = this.db.Exec(sqlFmt, sqlValue...)
+	ret, err = this.db.ExecContext(ctx, sqlFmt, sqlValue...)
 	result.Result = ret
 
-	result.Err = err
+	result.Err = wrapError(err)
 
-	if result.Err != nil && len(result.Err.Error()) == 0 {
-		result.Err = &NetError{s: "empty error msg"}
+	if err != nil && len(err.Error()) == 0 {
+		result.Err = &NetError{s: "empty error msg", err: err}
 	}
 
 	if Debug && err != nil {
@@ -487,21 +823,29 @@ func (this *Client) exec(sqlFmt string, sqlValue ...interface{}) *ClientExecResu
 // 支持完整的SQL语句与?占位符.对于?占位符的使用请参考官方文档
 // ?占位符是字符串安全的,请尽量使用?占位符
 func (this *Client) query(sqlFmt string, sqlValue ...interface{}) *ClientQueryResult {
+	return this.queryContext(context.Background(), sqlFmt, sqlValue...)
+}
+
+func (this *Client) queryContext(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientQueryResult {
+	return this.interceptQuery(ctx, &QueryInfo{Op: OpQuery, SQL: sqlFmt, Args: sqlValue}, this.queryDB)
+}
 
-	result := new(ClientQueryResult)
+func (this *Client) queryDB(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientQueryResult {
+
+	result := &ClientQueryResult{db: &this.Sql}
 
 	if err := this.connect(); err != nil {
-		result.Err = err
+		result.Err = wrapError(err)
 		return result
 	}
 
-	rows, err := this.db.Query(sqlFmt, sqlValue...)
+	rows, err := this.db.QueryContext(ctx, sqlFmt, sqlValue...)
 	result.Rows = rows
 
-	result.Err = err
+	result.Err = wrapError(err)
 
-	if result.Err != nil && len(result.Err.Error()) == 0 {
-		result.Err = &NetError{s: "empty error msg"}
+	if err != nil && len(err.Error()) == 0 {
+		result.Err = &NetError{s: "empty error msg", err: err}
 	}
 
 	if Debug && err != nil {
@@ -543,14 +887,42 @@ func (this *Client) Close() error {
 
 // ping
 func (this *Client) Ping() error {
+	return this.PingContext(context.Background())
+}
+
+// PingContext 同 Ping, 使用 ctx 控制超时
+func (this *Client) PingContext(ctx context.Context) error {
 	if err := this.connect(); err != nil {
-		return &NetError{s: "ping error:" + err.Error()}
+		return &NetError{s: "ping error:" + err.Error(), err: err}
 	}
-	return this.db.Ping()
+	return this.db.PingContext(ctx)
 }
 
 // 开启事务
 func (this *Client) Begin() (*Transaction, error) {
+	return this.BeginTx(context.Background(), nil)
+}
+
+// BeginTx 开启事务, ctx 在事务提交或回滚前被取消时事务会被自动回滚.
+// opts 可以为 nil, 此时使用数据库默认的隔离级别
+func (this *Client) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Transaction, error) {
+
+	var options TxOptions
+
+	if opts != nil {
+		options.Isolation = opts.Isolation
+		options.ReadOnly = opts.ReadOnly
+	}
+
+	return this.BeginWithOptions(ctx, options)
+}
+
+// BeginWithOptions 以指定的隔离级别、只读模式或一致性快照开启事务
+func (this *Client) BeginWithOptions(ctx context.Context, options TxOptions) (*Transaction, error) {
+
+	if options.ConsistentSnapshot && options.Isolation != sql.LevelDefault && options.Isolation != sql.LevelRepeatableRead {
+		return nil, &SQLError{s: "consistent snapshot requires REPEATABLE READ isolation level"}
+	}
 
 	if this.db == nil {
 		err := this.connect()
@@ -559,21 +931,45 @@ func (this *Client) Begin() (*Transaction, error) {
 		}
 	}
 
-	tx, err := this.db.Begin()
+	var tx *sql.Tx
+
+	err := this.intercept(ctx, &QueryInfo{Op: OpBegin}, func(ctx context.Context, info *QueryInfo) error {
+		var err error
+		tx, err = this.db.BeginTx(ctx, &sql.TxOptions{Isolation: options.Isolation, ReadOnly: options.ReadOnly})
+		return err
+	})
 
 	if Debug && err != nil {
 		log.Println("[Litedb Debug] begin transaction error:", err)
 	}
 
 	if err != nil {
-		return nil, err
+		// 拦截器在开启事务之后返回错误
+		if tx != nil {
+			tx.Rollback()
+		}
+		return nil, wrapError(err)
 	}
 
 	tran := new(Transaction)
 	tran.tx = tx
 	tran.db = this.db
-	tran.Exec = tran.exec
-	tran.Query = tran.query
+	tran.seq = new(int)
+	tran.options = options
+	tran.ctx = ctx
+	tran.router = this.router
+	tran.batch = this.batch
+	tran.softDelete = this.softDelete
+	tran.interceptors = this.interceptors
+	tran.init()
+
+	if options.ConsistentSnapshot {
+		if err := tran.startConsistentSnapshot(ctx); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
 	return tran, nil
 }
 
@@ -582,14 +978,22 @@ func (this *Client) Begin() (*Transaction, error) {
 // 支持完整的SQL语句与?占位符.对于?占位符的使用请参考官方文档
 // ?占位符是字符串安全的,请尽量使用?占位符
 func (this *Transaction) exec(sqlFmt string, sqlValue ...interface{}) *ClientExecResult {
+	return this.execContext(context.Background(), sqlFmt, sqlValue...)
+}
+
+func (this *Transaction) execContext(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientExecResult {
+	return this.interceptExec(ctx, &QueryInfo{Op: OpExec, SQL: sqlFmt, Args: sqlValue, InTransaction: true}, this.execTx)
+}
+
+func (this *Transaction) execTx(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientExecResult {
 
 	result := new(ClientExecResult)
 	var ret sql.Result
 	var err error
 
-	ret, err = this.tx.Exec(sqlFmt, sqlValue...)
+	ret, err = this.tx.ExecContext(ctx, sqlFmt, sqlValue...)
 	result.Result = ret
-	result.Err = err
+	result.Err = wrapError(err)
 	if Debug && err != nil {
 		log.Println("[Litedb Debug] exec transaction error:", err, sqlFmt, sqlValue)
 	}
@@ -601,12 +1005,20 @@ func (this *Transaction) exec(sqlFmt string, sqlValue ...interface{}) *ClientExe
 // 支持完整的SQL语句与?占位符.对于?占位符的使用请参考官方文档
 // ?占位符是字符串安全的,请尽量使用?占位符
 func (this *Transaction) query(sqlFmt string, sqlValue ...interface{}) *ClientQueryResult {
+	return this.queryContext(context.Background(), sqlFmt, sqlValue...)
+}
+
+func (this *Transaction) queryContext(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientQueryResult {
+	return this.interceptQuery(ctx, &QueryInfo{Op: OpQuery, SQL: sqlFmt, Args: sqlValue, InTransaction: true}, this.queryTx)
+}
 
-	result := new(ClientQueryResult)
+func (this *Transaction) queryTx(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientQueryResult {
 
-	rows, err := this.tx.Query(sqlFmt, sqlValue...)
+	result := &ClientQueryResult{db: &this.Sql}
+
+	rows, err := this.tx.QueryContext(ctx, sqlFmt, sqlValue...)
 	result.Rows = rows
-	result.Err = err
+	result.Err = wrapError(err)
 	if Debug && err != nil {
 		log.Println("[Litedb Debug] query transaction error:", err, sqlFmt, sqlValue)
 	}
@@ -614,14 +1026,34 @@ func (this *Transaction) query(sqlFmt string, sqlValue ...interface{}) *ClientQu
 
 }
 
+func (this *Transaction) init() {
+	this.Exec = this.exec
+	this.Query = this.query
+	this.ExecContext = this.execContext
+	this.QueryContext = this.queryContext
+	this.beginTx = this.beginNested
+}
+
 // 提交事务
+// 嵌套事务的提交仅释放对应的保存点, 数据在最外层事务提交时才真正写入
 func (this *Transaction) Commit() error {
+	return this.intercept(this.ctx, &QueryInfo{Op: OpCommit, InTransaction: true}, func(ctx context.Context, info *QueryInfo) error {
+		return this.commit()
+	})
+}
+
+func (this *Transaction) commit() error {
+
+	if this.parent != nil {
+		return this.finishNested(true)
+	}
 
 	err := this.tx.Commit()
+	this.done = true
 	if Debug && err != nil {
 		log.Println("[Litedb Debug] commit transaction error:", err)
 	}
-	return err
+	return wrapError(err)
 }
 
 // 回滚事务
@@ -631,13 +1063,25 @@ func (this *Transaction) Roolback() error {
 }
 
 // 回滚事务
+// 嵌套事务的回滚仅回滚到对应的保存点, 外层事务可以继续执行
 func (this *Transaction) Rollback() error {
+	return this.intercept(this.ctx, &QueryInfo{Op: OpRollback, InTransaction: true}, func(ctx context.Context, info *QueryInfo) error {
+		return this.rollback()
+	})
+}
+
+func (this *Transaction) rollback() error {
+
+	if this.parent != nil {
+		return this.finishNested(false)
+	}
 
 	err := this.tx.Rollback()
+	this.done = true
 	if Debug && err != nil {
 		log.Println("[Litedb Debug] rollback transaction error:", err)
 	}
-	return err
+	return wrapError(err)
 }
 
 // =======================================================================================================
@@ -0,0 +1,744 @@
+package litedb
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sqlPerson struct {
+	Id   int64  `db:"id"`
+	Name string `db:"name"`
+	Age  int    `db:"age"`
+	City string `db:"city"`
+	Memo string `db:"-"`
+}
+
+// 记录 Exec 收到的 SQL 与参数, 不连接数据库
+func recordSql() (*Sql, *[]string, *[][]interface{}) {
+
+	sqls := make([]string, 0)
+	args := make([][]interface{}, 0)
+
+	s := &Sql{
+		Exec: func(sqlFmt string, sqlValue ...interface{}) *ClientExecResult {
+			sqls = append(sqls, sqlFmt)
+			args = append(args, sqlValue)
+			return new(ClientExecResult)
+		},
+	}
+
+	return s, &sqls, &args
+}
+
+func TestGeneratedSqlIsDeterministic(t *testing.T) {
+
+	p := &sqlPerson{Id: 1, Name: "litedb", Age: 18, City: "hz"}
+	m := map[string]interface{}{"name": "litedb", "age": 18, "city": "hz", "id": 1}
+
+	cases := []struct {
+		name   string
+		run    func(s *Sql) *ClientExecResult
+		expect string
+		args   []interface{}
+	}{
+		{
+			"Insert",
+			func(s *Sql) *ClientExecResult { return s.Insert("person", p) },
+			"INSERT INTO `person` (`id`,`name`,`age`,`city`) VALUES (?,?,?,?);",
+			[]interface{}{"1", "litedb", "18", "hz"},
+		},
+		{
+			"InsertMap",
+			func(s *Sql) *ClientExecResult { return s.Insert("person", m) },
+			"INSERT INTO `person` (`age`,`city`,`id`,`name`) VALUES (?,?,?,?);",
+			[]interface{}{"18", "hz", "1", "litedb"},
+		},
+		{
+			"Update",
+			func(s *Sql) *ClientExecResult { return s.Update("person", p, "id = ?", 1) },
+			"UPDATE `person` SET `id`=?,`name`=?,`age`=?,`city`=? WHERE id = ?",
+			[]interface{}{"1", "litedb", "18", "hz", 1},
+		},
+		{
+			"UpdateFields",
+			func(s *Sql) *ClientExecResult {
+				return s.UpdateFields("person", p, []string{"city", "name"}, "id = ?", 1)
+			},
+			"UPDATE `person` SET `city`=?,`name`=? WHERE id = ?",
+			[]interface{}{"hz", "litedb", 1},
+		},
+		{
+			"InsertOrUpdate",
+			func(s *Sql) *ClientExecResult { return s.InsertOrUpdate("person", p) },
+			"INSERT INTO `person` (`id`,`name`,`age`,`city`) VALUES (?,?,?,?) ON DUPLICATE KEY UPDATE  `id`=?,`name`=?,`age`=?,`city`=?",
+			[]interface{}{"1", "litedb", "18", "hz", "1", "litedb", "18", "hz"},
+		},
+		{
+			"InsertOrUpdateFields",
+			func(s *Sql) *ClientExecResult { return s.InsertOrUpdateFields("person", p, "age", "name") },
+			"INSERT INTO `person` (`id`,`name`,`age`,`city`) VALUES (?,?,?,?) ON DUPLICATE KEY UPDATE  `age`=?,`name`=?",
+			[]interface{}{"1", "litedb", "18", "hz", "18", "litedb"},
+		},
+		{
+			"BatchInsert",
+			func(s *Sql) *ClientExecResult { return s.BatchInsert("person", []sqlPerson{*p, *p}) },
+			"INSERT INTO `person` (`id`,`name`,`age`,`city`) VALUES (?,?,?,?),(?,?,?,?)",
+			[]interface{}{"1", "litedb", "18", "hz", "1", "litedb", "18", "hz"},
+		},
+		{
+			"BatchInsertOrUpdate",
+			func(s *Sql) *ClientExecResult {
+				return s.BatchInsertOrUpdate("person", []sqlPerson{*p, *p}, "age", "name")
+			},
+			"INSERT INTO `person` (`id`,`name`,`age`,`city`) VALUES (?,?,?,?),(?,?,?,?) ON DUPLICATE KEY UPDATE `age`=VALUES(`age`),`name`=VALUES(`name`)",
+			[]interface{}{"1", "litedb", "18", "hz", "1", "litedb", "18", "hz"},
+		},
+		{
+			"BatchInsertIgnore",
+			func(s *Sql) *ClientExecResult { return s.BatchInsertIgnore("person", []sqlPerson{*p}) },
+			"INSERT IGNORE INTO `person` (`id`,`name`,`age`,`city`) VALUES (?,?,?,?)",
+			[]interface{}{"1", "litedb", "18", "hz"},
+		},
+	}
+
+	for _, c := range cases {
+
+		s, sqls, args := recordSql()
+
+		for i := 0; i < 20; i++ {
+			if r := c.run(s); r.Err != nil {
+				t.Fatalf("%s: %v", c.name, r.Err)
+			}
+		}
+
+		for i, got := range *sqls {
+			if got != c.expect {
+				t.Fatalf("%s: run %d sql = %q, want %q", c.name, i, got, c.expect)
+			}
+			if !reflect.DeepEqual((*args)[i], c.args) {
+				t.Fatalf("%s: run %d args = %v, want %v", c.name, i, (*args)[i], c.args)
+			}
+		}
+	}
+}
+
+func TestTableRouter(t *testing.T) {
+
+	s, sqls, _ := recordSql()
+	s.SetTableRouter(personRouter(t))
+
+	s.Insert("person", &sqlPerson{Id: 7, Name: "a"})
+	s.Update("person", map[string]interface{}{"id": 123}, "id = ?", 123)
+	s.Route(42).Delete("person", "id = ?", 42)
+	s.BatchInsert("person", []sqlPerson{{Id: 1}, {Id: 2}, {Id: 101}})
+
+	expect := []string{
+		"INSERT INTO `person_07` (`id`,`name`,`age`,`city`) VALUES (?,?,?,?);",
+		"UPDATE `person_23` SET `id`=? WHERE id = ?",
+		"DELETE FROM `person_42` WHERE id = ?",
+		"INSERT INTO `person_01` (`id`,`name`,`age`,`city`) VALUES (?,?,?,?),(?,?,?,?)",
+		"INSERT INTO `person_02` (`id`,`name`,`age`,`city`) VALUES (?,?,?,?)",
+	}
+
+	if !reflect.DeepEqual(*sqls, expect) {
+		t.Fatalf("sql = %q, want %q", *sqls, expect)
+	}
+
+	if r := s.Delete("person", "id = ?", 1); r.Err == nil {
+		t.Fatal("missing route value expected")
+	}
+
+	if table, _ := s.Table("person", 5); table != "person_05" {
+		t.Fatal("unexpected table", table)
+	}
+
+	if err := NewSplitTableRouter().Add("order", "user_id", 0, nil); err == nil || !strings.Contains(err.Error(), "SplitTableRouter.Add") {
+		t.Fatal("Add should reject a non-positive table count, got", err)
+	}
+}
+
+// person 按 id 拆分为 100 张表
+func personRouter(t *testing.T) *SplitTableRouter {
+
+	router := NewSplitTableRouter()
+
+	if err := router.Add("person", "id", 100, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return router
+}
+
+func TestBatchChunks(t *testing.T) {
+
+	s, sqls, _ := recordSql()
+	s.SetBatchOptions(BatchOptions{MaxRows: 2, MaxBytes: 1 << 20})
+
+	list := []sqlPerson{{Id: 1}, {Id: 2}, {Id: 3}, {Id: 4}, {Id: 5}}
+
+	if r := s.BatchInsert("person", list); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+
+	if len(*sqls) != 3 || (*sqls)[2] != "INSERT INTO `person` (`id`,`name`,`age`,`city`) VALUES (?,?,?,?)" {
+		t.Fatalf("unexpected chunks: %q", *sqls)
+	}
+
+	// 语句头部预留 1055 字节, 每行约 49 字节, 每批最多两行
+	if chunks := chunkRows("person", mustValues(t, list), BatchOptions{MaxBytes: 1155}); len(chunks) != 3 {
+		t.Fatal("chunk by bytes expected", len(chunks))
+	}
+
+	fail := errors.New("fail")
+	n := 0
+	s.Exec = func(sqlFmt string, sqlValue ...interface{}) *ClientExecResult {
+		if n++; n == 2 {
+			return &ClientExecResult{Err: fail}
+		}
+		return &ClientExecResult{Result: driver.RowsAffected(2)}
+	}
+
+	r := s.BatchReplace("person", list)
+
+	var batchErr *BatchError
+
+	if !errors.As(r.Err, &batchErr) || batchErr.Chunk != 1 || batchErr.Chunks != 3 || batchErr.RowsAffected != 2 || !errors.Is(r.Err, fail) {
+		t.Fatal("unexpected error", r.Err)
+	}
+
+	client := newTxClient(t)
+	client.SetBatchOptions(BatchOptions{MaxRows: 1, MaxBytes: 1 << 20, Transaction: true})
+
+	r = client.BatchInsert("person", list[:2])
+
+	if affected, _ := r.Result.RowsAffected(); r.Err != nil || affected != 2 {
+		t.Fatal("unexpected result", r.Err, affected)
+	}
+
+	if got := strings.Join(testTxDriver.reset(), ","); got != "begin,"+strings.Repeat("INSERT INTO `person` (`id`,`name`,`age`,`city`) VALUES (?,?,?,?),", 2)+"commit" {
+		t.Fatal("unexpected events:", got)
+	}
+}
+
+func mustValues(t *testing.T, vs interface{}) []*rowValues {
+
+	list, err := listStructToValues(vs)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return list
+}
+
+func TestBatchInsertOrUpdateRowAlias(t *testing.T) {
+
+	s, sqls, _ := recordSql()
+	s.SetBatchOptions(BatchOptions{MaxBytes: 1 << 20, RowAlias: true})
+
+	s.BatchInsertOrUpdate("person", []sqlPerson{{Id: 1}, {Id: 2}})
+
+	expect := "INSERT INTO `person` (`id`,`name`,`age`,`city`) VALUES (?,?,?,?),(?,?,?,?) AS `new` ON DUPLICATE KEY UPDATE `id`=`new`.`id`,`name`=`new`.`name`,`age`=`new`.`age`,`city`=`new`.`city`"
+
+	if (*sqls)[0] != expect {
+		t.Fatalf("sql = %q, want %q", (*sqls)[0], expect)
+	}
+
+	if r := s.BatchInsertOrUpdate("person", []sqlPerson{{Id: 1}}, "unknown"); r.Err == nil {
+		t.Fatal("nothing update expected")
+	}
+}
+
+type autoPerson struct {
+	Id   int64  `db:"id,pk,auto"`
+	Name string `db:"name"`
+}
+
+// 模拟数据库生成的自增 ID
+type insertResult int64
+
+func (this insertResult) LastInsertId() (int64, error) { return int64(this), nil }
+func (this insertResult) RowsAffected() (int64, error) { return 1, nil }
+
+func TestAutoIncrementIds(t *testing.T) {
+
+	s, sqls, _ := recordSql()
+	s.SetBatchOptions(BatchOptions{MaxRows: 2, MaxBytes: 1 << 20})
+
+	next := int64(100)
+	exec := s.Exec
+	s.Exec = func(sqlFmt string, sqlValue ...interface{}) *ClientExecResult {
+		exec(sqlFmt, sqlValue...)
+		r := &ClientExecResult{Result: insertResult(next)}
+		next += 10
+		return r
+	}
+
+	p := &autoPerson{Name: "a"}
+
+	if r := s.Insert("person", p); r.Err != nil || p.Id != 100 {
+		t.Fatal("id should be populated", r.Err, p.Id)
+	}
+
+	list := []autoPerson{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+
+	if r := s.BatchInsert("person", list); r.Err != nil || list[0].Id != 110 || list[1].Id != 111 || list[2].Id != 120 {
+		t.Fatalf("ids should be populated: %v %+v", r.Err, list)
+	}
+
+	pointers := []*autoPerson{{Name: "d"}, {Name: "e"}}
+
+	if r := s.BatchInsert("person", pointers); r.Err != nil || pointers[0].Id != 130 || pointers[1].Id != 131 {
+		t.Fatalf("ids should be written back through pointers: %v %+v %+v", r.Err, pointers[0], pointers[1])
+	}
+
+	explicit := &autoPerson{Id: 7, Name: "a"}
+	s.Insert("person", explicit)
+
+	expect := []string{
+		"INSERT INTO `person` (`name`) VALUES (?);",
+		"INSERT INTO `person` (`name`) VALUES (?),(?)",
+		"INSERT INTO `person` (`name`) VALUES (?)",
+		"INSERT INTO `person` (`name`) VALUES (?),(?)",
+		"INSERT INTO `person` (`id`,`name`) VALUES (?,?);",
+	}
+
+	if !reflect.DeepEqual(*sqls, expect) || explicit.Id != 7 {
+		t.Fatalf("sql = %q, want %q", *sqls, expect)
+	}
+
+	detects := 0
+	s.Query = func(sqlFmt string, sqlValue ...interface{}) *ClientQueryResult {
+		detects++
+		return &ClientQueryResult{Err: errors.New("server is down")}
+	}
+	s.Exec = func(sqlFmt string, sqlValue ...interface{}) *ClientExecResult {
+		return &ClientExecResult{Err: errors.New("duplicate entry")}
+	}
+
+	if r := s.BatchInsert("person", []autoPerson{{Name: "f"}}); r.Err == nil || detects != 0 {
+		t.Fatalf("failed statements should not detect the increment: %v, %d detects", r.Err, detects)
+	}
+}
+
+type tagAddress struct {
+	City string `db:"city"`
+	Zip  string `db:"zip,omitempty"`
+}
+
+type tagPerson struct {
+	Id      int64             `db:"id,pk,auto"`
+	Name    string            `db:"name"`
+	Nick    string            `db:"nick,omitempty"`
+	Status  int               `db:"status,default=1"`
+	Score   int               `db:"score,default"`
+	Total   int               `db:"total,readonly"`
+	Tags    []string          `db:"tags,json"`
+	Extra   map[string]string `db:"extra,json"`
+	Home    tagAddress        `db:",inline,prefix=home_"`
+	Ignored tagAddress
+}
+
+func TestTagOptions(t *testing.T) {
+
+	s, sqls, args := recordSql()
+
+	p := &tagPerson{Id: 3, Name: "a", Tags: []string{"x"}, Home: tagAddress{City: "hz"}}
+
+	s.Insert("person", p)
+	s.Update("person", p, "id = ?", 3)
+	s.InsertOrUpdate("person", p)
+	s.BatchInsert("person", []tagPerson{{Name: "a", Nick: "n"}, {Name: "b", Score: 2}})
+
+	expect := []string{
+		"INSERT INTO `person` (`id`,`name`,`status`,`tags`,`extra`,`home_city`) VALUES (?,?,?,?,?,?);",
+		"UPDATE `person` SET `name`=?,`status`=?,`score`=?,`tags`=?,`extra`=?,`home_city`=? WHERE id = ?",
+		"INSERT INTO `person` (`id`,`name`,`status`,`tags`,`extra`,`home_city`) VALUES (?,?,?,?,?,?) ON DUPLICATE KEY UPDATE  `name`=?,`status`=?,`score`=?,`tags`=?,`extra`=?,`home_city`=?",
+		"INSERT INTO `person` (`name`,`nick`,`status`,`tags`,`extra`,`home_city`,`score`) VALUES (?,?,?,?,?,?,DEFAULT),(?,DEFAULT,?,?,?,?,?)",
+	}
+
+	if !reflect.DeepEqual(*sqls, expect) {
+		t.Fatalf("sql = %q, want %q", *sqls, expect)
+	}
+
+	if got := (*args)[0]; !reflect.DeepEqual(got, []interface{}{"3", "a", "1", `["x"]`, nil, "hz"}) {
+		t.Fatalf("insert args = %#v", got)
+	}
+
+	if got := (*args)[1]; !reflect.DeepEqual(got, []interface{}{"a", "0", "0", `["x"]`, nil, "hz", 3}) {
+		t.Fatalf("update args = %#v", got)
+	}
+
+	row := map[string]*string{}
+	for k, v := range map[string]string{"id": "5", "total": "9", "tags": `["y","z"]`, "extra": `{"k":"v"}`, "home_city": "sh", "home_zip": "200000"} {
+		v := v
+		row[k] = &v
+	}
+
+	var got tagPerson
+
+	if err := mapToStruct(row, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Id != 5 || got.Total != 9 || !reflect.DeepEqual(got.Tags, []string{"y", "z"}) || got.Extra["k"] != "v" || got.Home.City != "sh" || got.Home.Zip != "200000" {
+		t.Fatalf("unexpected struct: %+v", got)
+	}
+}
+
+type versionPerson struct {
+	Id      int64  `db:"id,pk"`
+	Name    string `db:"name"`
+	Version int    `db:"version,version"`
+}
+
+func TestUpdateWithVersion(t *testing.T) {
+
+	s, sqls, args := recordSql()
+
+	affected := int64(1)
+	exec := s.Exec
+	s.Exec = func(sqlFmt string, sqlValue ...interface{}) *ClientExecResult {
+		exec(sqlFmt, sqlValue...)
+		return &ClientExecResult{Result: driver.RowsAffected(affected)}
+	}
+
+	p := &versionPerson{Id: 1, Name: "a", Version: 3}
+
+	if r := s.UpdateWithVersion("person", p, "id = ?", 1); r.Err != nil || p.Version != 4 {
+		t.Fatal("version should be bumped", r.Err, p.Version)
+	}
+
+	expect := "UPDATE `person` SET `name`=?,`version`=`version`+1 WHERE (id = ?) AND `version`=?"
+
+	if (*sqls)[0] != expect || !reflect.DeepEqual((*args)[0], []interface{}{"a", 1, int64(3)}) {
+		t.Fatalf("sql = %q %v, want %q", (*sqls)[0], (*args)[0], expect)
+	}
+
+	affected = 0
+
+	r := s.UpdateWithVersion("person", p, "id = ?", 1)
+
+	var stale *StaleObjectError
+
+	if !errors.As(r.Err, &stale) || stale.Version != 4 || p.Version != 4 {
+		t.Fatal("stale object error expected", r.Err, p.Version)
+	}
+
+	if r := s.UpdateWithVersion("person", *p, "id = ?", 1); r.Err == nil {
+		t.Fatal("struct pointer required")
+	}
+}
+
+type timePerson struct {
+	Id        int64     `db:"id,pk"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at,autoCreateTime"`
+	UpdatedAt int64     `db:"updated_at,autoUpdateTime"`
+}
+
+func TestAutoTime(t *testing.T) {
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, TimeLocation)
+	Clock = func() time.Time { return now }
+	defer func() { Clock = time.Now }()
+
+	s, sqls, args := recordSql()
+
+	p := &timePerson{Id: 1, Name: "a"}
+
+	s.Insert("person", p)
+
+	if !p.CreatedAt.Equal(now) || p.UpdatedAt != now.Unix() {
+		t.Fatalf("timestamps should be populated: %+v", p)
+	}
+
+	created := now
+	now = now.Add(time.Hour)
+
+	s.Update("person", p, "id = ?", 1)
+	s.UpdateFields("person", p, []string{"name"}, "id = ?", 1)
+	s.InsertOrUpdate("person", p)
+	s.InsertOrUpdateFields("person", p, "name", "created_at")
+	s.BatchInsertOrUpdate("person", []timePerson{{Id: 2}}, "name", "created_at")
+
+	if !p.CreatedAt.Equal(created) || p.UpdatedAt != now.Unix() {
+		t.Fatalf("created_at should not be overwritten: %+v", p)
+	}
+
+	expect := []string{
+		"INSERT INTO `person` (`id`,`name`,`created_at`,`updated_at`) VALUES (?,?,?,?);",
+		"UPDATE `person` SET `name`=?,`updated_at`=? WHERE id = ?",
+		"UPDATE `person` SET `name`=?,`updated_at`=? WHERE id = ?",
+		"INSERT INTO `person` (`id`,`name`,`created_at`,`updated_at`) VALUES (?,?,?,?) ON DUPLICATE KEY UPDATE  `name`=?,`updated_at`=?",
+		"INSERT INTO `person` (`id`,`name`,`created_at`,`updated_at`) VALUES (?,?,?,?) ON DUPLICATE KEY UPDATE  `name`=?,`updated_at`=?",
+		"INSERT INTO `person` (`id`,`name`,`created_at`,`updated_at`) VALUES (?,?,?,?) ON DUPLICATE KEY UPDATE `name`=VALUES(`name`),`updated_at`=VALUES(`updated_at`)",
+	}
+
+	if !reflect.DeepEqual(*sqls, expect) {
+		t.Fatalf("sql = %q, want %q", *sqls, expect)
+	}
+
+	if got := (*args)[5]; got[2] != "2024-01-02 04:04:05" || got[3] != ToStr(now.Unix()) {
+		t.Fatalf("batch args = %v", got)
+	}
+}
+
+type softPerson struct {
+	Id        int64      `db:"id,pk"`
+	Name      string     `db:"name"`
+	DeletedAt *time.Time `db:"deleted_at,softDelete"`
+}
+
+func TestSoftDelete(t *testing.T) {
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, TimeLocation)
+	Clock = func() time.Time { return now }
+	defer func() { Clock = time.Now }()
+
+	s, sqls, args := recordSql()
+
+	if err := s.SetSoftDeleteModel("person", softPerson{}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.SetSoftDelete("order", "is_deleted", SoftDeleteFlag)
+
+	if err := s.SetSoftDeleteModel("city", sqlPerson{}); err == nil {
+		t.Fatal("model without softDelete field should be rejected")
+	}
+
+	s.Insert("person", &softPerson{Id: 1, Name: "a"})
+	s.Update("person", &softPerson{Id: 1, Name: "b"}, "id = ?", 1)
+	s.Delete("person", "id = ?", 1)
+	s.Restore("person", "id = ?", 1)
+	s.HardDelete("person", "id = ?", 1)
+	s.Delete("order", "id = ?", 2)
+	s.Restore("order", "id = ?", 2)
+	s.Delete("city", "id = ?", 3)
+
+	expect := []string{
+		"INSERT INTO `person` (`id`,`name`,`deleted_at`) VALUES (?,?,?);",
+		"UPDATE `person` SET `name`=? WHERE id = ?",
+		"UPDATE `person` SET `deleted_at`=? WHERE (id = ?) AND `deleted_at` IS NULL",
+		"UPDATE `person` SET `deleted_at`=? WHERE (id = ?) AND `deleted_at` IS NOT NULL",
+		"DELETE FROM `person` WHERE id = ?",
+		"UPDATE `order` SET `is_deleted`=? WHERE (id = ?) AND `is_deleted`=0",
+		"UPDATE `order` SET `is_deleted`=? WHERE (id = ?) AND `is_deleted`<>0",
+		"DELETE FROM `city` WHERE id = ?",
+	}
+
+	if !reflect.DeepEqual(*sqls, expect) {
+		t.Fatalf("sql = %q, want %q", *sqls, expect)
+	}
+
+	if got := (*args)[0][2]; got != nil {
+		t.Fatalf("deleted_at should be inserted as NULL, got %v", got)
+	}
+
+	if got := (*args)[2]; !reflect.DeepEqual(got, []interface{}{"2024-01-02 03:04:05", 1}) {
+		t.Fatalf("delete args = %v", got)
+	}
+
+	if got := (*args)[3]; !reflect.DeepEqual(got, []interface{}{nil, 1}) {
+		t.Fatalf("restore args = %v", got)
+	}
+
+	if got := (*args)[5]; !reflect.DeepEqual(got, []interface{}{1, 2}) {
+		t.Fatalf("flag delete args = %v", got)
+	}
+
+	if cond, ok := s.NotDeleted("person"); !ok || cond != "`deleted_at` IS NULL" {
+		t.Fatal("unexpected not deleted condition", cond)
+	}
+
+	if cond, ok := s.NotDeleted("city"); ok || cond != "" {
+		t.Fatal("table without soft delete should have no condition", cond)
+	}
+
+	if r := s.Restore("city", "id = ?", 3); r.Err == nil {
+		t.Fatal("restore on table without soft delete should fail")
+	}
+}
+
+type softStampPerson struct {
+	Id        int64     `db:"id,pk"`
+	UpdatedAt time.Time `db:"updated_at,autoUpdateTime"`
+	IsDeleted bool      `db:"is_deleted,softDelete"`
+}
+
+func TestSoftDeleteUpdateTime(t *testing.T) {
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, TimeLocation)
+	Clock = func() time.Time { return now }
+	defer func() { Clock = time.Now }()
+
+	s, sqls, args := recordSql()
+
+	if err := s.SetSoftDeleteModel("person", &softStampPerson{}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Delete("person", "id = ?", 1)
+	s.Restore("person", "id = ?", 1)
+
+	expect := []string{
+		"UPDATE `person` SET `is_deleted`=?,`updated_at`=? WHERE (id = ?) AND `is_deleted`=0",
+		"UPDATE `person` SET `is_deleted`=?,`updated_at`=? WHERE (id = ?) AND `is_deleted`<>0",
+	}
+
+	if !reflect.DeepEqual(*sqls, expect) {
+		t.Fatalf("sql = %q, want %q", *sqls, expect)
+	}
+
+	if got := (*args)[0]; !reflect.DeepEqual(got, []interface{}{1, "2024-01-02 03:04:05", 1}) {
+		t.Fatalf("delete args = %v", got)
+	}
+
+	if got := (*args)[1]; !reflect.DeepEqual(got, []interface{}{0, "2024-01-02 03:04:05", 1}) {
+		t.Fatalf("restore args = %v", got)
+	}
+}
+
+type hookPerson struct {
+	Id   int64  `db:"id,pk,auto"`
+	Name string `db:"name"`
+
+	events []string
+	db     *Sql
+}
+
+func (this *hookPerson) record(db *Sql, event string) error {
+
+	this.events = append(this.events, event)
+	this.db = db
+
+	if this.Name == "invalid" {
+		return errors.New(event + " rejected")
+	}
+
+	return nil
+}
+
+func (this *hookPerson) BeforeInsert(db *Sql) error { return this.record(db, "BeforeInsert") }
+func (this *hookPerson) AfterInsert(db *Sql) error  { return this.record(db, "AfterInsert") }
+func (this *hookPerson) BeforeUpdate(db *Sql) error { return this.record(db, "BeforeUpdate") }
+func (this *hookPerson) AfterUpdate(db *Sql) error  { return this.record(db, "AfterUpdate") }
+func (this *hookPerson) BeforeDelete(db *Sql) error { return this.record(db, "BeforeDelete") }
+
+type hookRow struct {
+	benchRow
+	found *Sql
+}
+
+func (this *hookRow) AfterFind(db *Sql) error {
+	this.found = db
+	this.Name = strings.ToUpper(this.Name)
+	return nil
+}
+
+func TestHooks(t *testing.T) {
+
+	s, sqls, _ := recordSql()
+
+	p := &hookPerson{Name: "a"}
+
+	s.Insert("person", p)
+	s.Update("person", p, "id = ?", 1)
+	s.InsertOrUpdate("person", p)
+	s.DeleteModel("person", p, "id = ?", 1)
+
+	expect := []string{
+		"BeforeInsert", "AfterInsert",
+		"BeforeUpdate", "AfterUpdate",
+		"BeforeInsert", "BeforeUpdate", "AfterInsert", "AfterUpdate",
+		"BeforeDelete",
+	}
+
+	if !reflect.DeepEqual(p.events, expect) || p.db != s {
+		t.Fatalf("events = %v, want %v", p.events, expect)
+	}
+
+	if len(*sqls) != 4 {
+		t.Fatalf("sql = %q", *sqls)
+	}
+
+	invalid := &hookPerson{Name: "invalid"}
+
+	if r := s.Insert("person", invalid); r.Err == nil || r.Err.Error() != "BeforeInsert rejected" {
+		t.Fatal("BeforeInsert error should abort the statement, got", r.Err)
+	}
+
+	rows := []hookPerson{{Name: "b"}, {Name: "invalid"}}
+
+	if r := s.BatchInsert("person", rows); r.Err == nil {
+		t.Fatal("BeforeInsert error should abort the batch")
+	}
+
+	if len(*sqls) != 4 {
+		t.Fatalf("aborted statements should not be executed: %q", *sqls)
+	}
+
+	rows[1].Name = "c"
+
+	if r := s.BatchInsertOrUpdate("person", rows); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+
+	if got := rows[1].events; !reflect.DeepEqual(got, []string{"BeforeInsert", "BeforeInsert", "BeforeUpdate", "AfterInsert", "AfterUpdate"}) {
+		t.Fatalf("batch events = %v", got)
+	}
+
+	result := benchQuery(2)
+	result.db = s
+
+	var found []hookRow
+
+	if err := result.ToStruct(&found); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != 2 || found[1].Name != "NAME-2" || found[1].found != s {
+		t.Fatalf("AfterFind should be called for each row: %+v", found)
+	}
+
+	var first hookRow
+
+	if err := benchQuery(1).FirstScanToStruct(&first); err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Name != "NAME-1" || first.found != nil {
+		t.Fatalf("AfterFind should be called with nil Sql: %+v", first)
+	}
+
+	result = benchQuery(1)
+	result.db = s
+
+	it := result.Iterator()
+	defer it.Close()
+
+	var scanned hookRow
+
+	if !it.Next() || it.Scan(&scanned) != nil || scanned.Name != "NAME-1" || scanned.found != s {
+		t.Fatalf("AfterFind should be called by RowIterator.Scan: %+v", scanned)
+	}
+
+	result = benchQuery(2)
+	result.db = s
+
+	var each []hookRow
+
+	err := Each(result, func(row hookRow) error {
+		each = append(each, row)
+		return nil
+	})
+
+	if err != nil || len(each) != 2 || each[1].Name != "NAME-2" || each[1].found != s {
+		t.Fatalf("AfterFind should be called by Each: %v %+v", err, each)
+	}
+}
@@ -0,0 +1,238 @@
+package litedb
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// 从库负载均衡策略
+type Balancer interface {
+	// 从 replicas 中选择一个从库, replicas 不会为空
+	Pick(replicas []*Client) *Client
+}
+
+// 轮询
+type RoundRobinBalancer struct {
+	n uint64
+}
+
+func (this *RoundRobinBalancer) Pick(replicas []*Client) *Client {
+	n := atomic.AddUint64(&this.n, 1)
+	return replicas[(n-1)%uint64(len(replicas))]
+}
+
+// 随机
+type RandomBalancer struct {
+}
+
+func (this *RandomBalancer) Pick(replicas []*Client) *Client {
+	return replicas[rand.Intn(len(replicas))]
+}
+
+// 最少连接, 根据 DBStats 中正在使用的连接数选择
+type LeastConnBalancer struct {
+}
+
+func (this *LeastConnBalancer) Pick(replicas []*Client) *Client {
+
+	picked := replicas[0]
+	least := -1
+
+	for _, replica := range replicas {
+		if replica.db == nil {
+			continue
+		}
+		if inUse := replica.DBStats().InUse; least < 0 || inUse < least {
+			picked = replica
+			least = inUse
+		}
+	}
+
+	return picked
+}
+
+// 读写分离客户端
+// Exec 以及基于 Exec 的 Insert/Update/Delete 等操作、事务都发送到主库,
+// Query 按照负载均衡策略发送到从库. 没有从库时全部发送到主库.
+// 需要读取刚刚写入的数据时, 使用 Primary() 直接操作主库
+type ClusterClient struct {
+	Sql
+	primary  *Client
+	replicas []*Client
+	balancer Balancer
+
+	mu      sync.RWMutex
+	healthy []*Client     // 参与负载均衡的从库, 由健康检查维护
+	nodes   []*nodeHealth // 主库以及全部从库的健康状态
+	stop    chan struct{}
+}
+
+// 初始化读写分离客户端, balancer 为 nil 时使用轮询.
+// 分表路由、软删除与批量拆分的设置在创建时从主库复制, 之后 ClusterClient 与主库的设置互不影响:
+// 创建之后请直接在 ClusterClient 上调用 SetTableRouter、SetSoftDelete 与 SetBatchOptions
+func NewClusterClient(primary *Client, replicas []*Client, balancer Balancer) *ClusterClient {
+
+	cluster := new(ClusterClient)
+	cluster.primary = primary
+	cluster.replicas = replicas
+	cluster.balancer = balancer
+
+	if cluster.balancer == nil {
+		cluster.balancer = new(RoundRobinBalancer)
+	}
+
+	cluster.healthy = replicas
+	cluster.nodes = append(cluster.nodes, newNodeHealth(primary, "primary"))
+
+	for _, replica := range replicas {
+		cluster.nodes = append(cluster.nodes, newNodeHealth(replica, "replica"))
+	}
+
+	cluster.Exec = primary.exec
+	cluster.ExecContext = primary.execContext
+	cluster.Query = cluster.query
+	cluster.QueryContext = cluster.queryContext
+	cluster.beginTx = cluster.BeginTx
+	cluster.router = primary.router
+	cluster.softDelete = primary.softDelete.clone()
+	cluster.server = &primary.Sql
+
+	// 拆分设置与主库互不影响, 初始值与主库相同
+	cluster.batch = new(batchConfig)
+
+	if primary.batch != nil {
+		primary.batch.mu.Lock()
+		cluster.batch.options = primary.batch.options
+		primary.batch.mu.Unlock()
+	}
+
+	return cluster
+}
+
+// 主库
+func (this *ClusterClient) Primary() *Client {
+	return this.primary
+}
+
+// 按负载均衡策略选择的从库, 没有健康的从库时返回主库
+func (this *ClusterClient) Replica() *Client {
+
+	this.mu.RLock()
+	healthy := this.healthy
+	this.mu.RUnlock()
+
+	if len(healthy) < 1 {
+		return this.primary
+	}
+
+	return this.balancer.Pick(healthy)
+}
+
+// 全部从库
+func (this *ClusterClient) Replicas() []*Client {
+	return this.replicas
+}
+
+func (this *ClusterClient) query(sqlFmt string, sqlValue ...interface{}) *ClientQueryResult {
+	return this.queryContext(context.Background(), sqlFmt, sqlValue...)
+}
+
+func (this *ClusterClient) queryContext(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientQueryResult {
+
+	result := this.Replica().queryContext(ctx, sqlFmt, sqlValue...)
+	result.db = &this.Sql
+
+	return result
+}
+
+// 为主库与全部从库添加拦截器, 见 Sql.Use
+func (this *ClusterClient) Use(interceptors ...Interceptor) {
+
+	this.primary.Use(interceptors...)
+
+	for _, replica := range this.replicas {
+		replica.Use(interceptors...)
+	}
+}
+
+// 在主库上开启事务
+func (this *ClusterClient) Begin() (*Transaction, error) {
+	return this.BeginTx(context.Background(), nil)
+}
+
+// 在主库上开启事务
+func (this *ClusterClient) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Transaction, error) {
+	return this.adopt(this.primary.BeginTx(ctx, opts))
+}
+
+// 开启事务, 只读事务会被路由到从库
+func (this *ClusterClient) BeginWithOptions(ctx context.Context, options TxOptions) (*Transaction, error) {
+
+	if options.ReadOnly {
+		return this.adopt(this.Replica().BeginWithOptions(ctx, options))
+	}
+
+	return this.adopt(this.primary.BeginWithOptions(ctx, options))
+}
+
+// 在主库上执行事务, 参见 Client.WithTransaction
+func (this *ClusterClient) WithTransaction(ctx context.Context, opts *sql.TxOptions, fn func(tx *Transaction) error) error {
+	return this.primary.WithTransaction(ctx, opts, func(tx *Transaction) error {
+		this.adopt(tx, nil)
+		return fn(tx)
+	})
+}
+
+// 事务使用 ClusterClient 而不是开启事务的 Client 上的设置
+func (this *ClusterClient) adopt(tx *Transaction, err error) (*Transaction, error) {
+
+	if err != nil {
+		return nil, err
+	}
+
+	tx.router = this.router
+	tx.batch = this.batch
+	tx.softDelete = this.softDelete
+
+	return tx, nil
+}
+
+// ping 主库与全部从库
+func (this *ClusterClient) Ping() error {
+	return this.PingContext(context.Background())
+}
+
+// PingContext 同 Ping, 使用 ctx 控制超时
+func (this *ClusterClient) PingContext(ctx context.Context) error {
+
+	if err := this.primary.PingContext(ctx); err != nil {
+		return err
+	}
+
+	for _, replica := range this.replicas {
+		if err := replica.PingContext(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 关闭主库与全部从库, 同时停止健康检查
+func (this *ClusterClient) Close() error {
+
+	this.StopHealthCheck()
+
+	var ret error
+
+	for _, client := range append([]*Client{this.primary}, this.replicas...) {
+		if err := client.Close(); err != nil && ret == nil {
+			ret = err
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,216 @@
+package litedb
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestClusterRouting(t *testing.T) {
+
+	primary := newTxClient(t)
+	replicas := []*Client{newTxClient(t), newTxClient(t)}
+
+	cluster := NewClusterClient(primary, replicas, nil)
+
+	if cluster.Replica() != replicas[0] || cluster.Replica() != replicas[1] || cluster.Replica() != replicas[0] {
+		t.Fatal("round robin expected")
+	}
+
+	if NewClusterClient(primary, nil, nil).Replica() != primary {
+		t.Fatal("primary expected without replicas")
+	}
+
+	testTxDriver.reset()
+
+	cluster.Insert("person", map[string]interface{}{"id": 1})
+
+	tx, err := cluster.Sql.Begin()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx.Commit()
+
+	if got := strings.Join(testTxDriver.reset(), ","); got != "INSERT INTO `person` (`id`) VALUES (?);,begin,commit" {
+		t.Fatal("unexpected events:", got)
+	}
+
+	if cluster.Primary() != primary {
+		t.Fatal("unexpected primary")
+	}
+
+	queries := make(map[*Client]int)
+
+	for _, c := range append([]*Client{primary}, replicas...) {
+		c := c
+		c.Use(func(ctx context.Context, info *QueryInfo, next Invoker) error {
+			queries[c]++
+			return next(ctx, info)
+		})
+	}
+
+	cluster.Query("SELECT 1")
+	cluster.Query("SELECT 2")
+
+	if queries[primary] != 0 || queries[replicas[0]] != 1 || queries[replicas[1]] != 1 {
+		t.Fatal("queries should be balanced over the replicas")
+	}
+}
+
+func TestClusterSettings(t *testing.T) {
+
+	primary := newTxClient(t)
+	primary.SetSoftDelete("person", "deleted_at", SoftDeleteTime)
+	primary.SetTableRouter(personRouter(t))
+
+	cluster := NewClusterClient(primary, []*Client{newTxClient(t)}, nil)
+
+	if _, ok := cluster.NotDeleted("person"); !ok || cluster.router == nil {
+		t.Fatal("settings of the primary should be copied")
+	}
+
+	primary.SetSoftDelete("order", "is_deleted", SoftDeleteFlag)
+	cluster.SetSoftDelete("city", "is_deleted", SoftDeleteFlag)
+
+	if _, ok := cluster.NotDeleted("order"); ok {
+		t.Fatal("later settings on the primary should not change the cluster")
+	}
+
+	if _, ok := primary.NotDeleted("city"); ok {
+		t.Fatal("settings on the cluster should not change the primary")
+	}
+}
+
+func TestClusterHealthCheck(t *testing.T) {
+
+	primary := newTxClient(t)
+	replicas := []*Client{newTxClient(t), newTxClient(t)}
+
+	cluster := NewClusterClient(primary, replicas, nil)
+	config := HealthCheckConfig{FailThreshold: 1, RecoverThreshold: 2}
+
+	replicas[1].db.Close()
+	cluster.CheckHealth(config)
+
+	for i := 0; i < 3; i++ {
+		if cluster.Replica() != replicas[0] {
+			t.Fatal("unhealthy replica should be ejected")
+		}
+	}
+
+	states := cluster.NodeStates()
+
+	if len(states) != 3 || !states[0].Healthy || !states[1].Healthy || states[2].Healthy || states[2].LastError == nil {
+		t.Fatalf("unexpected states: %+v", states)
+	}
+
+	replicas[0].db.Close()
+	cluster.CheckHealth(config)
+
+	if cluster.Replica() != primary {
+		t.Fatal("primary expected when no replica is healthy")
+	}
+
+	replicas[1].db = newTxClient(t).db
+	cluster.CheckHealth(config)
+
+	if cluster.Replica() != primary {
+		t.Fatal("replica should not recover before RecoverThreshold")
+	}
+
+	cluster.CheckHealth(config)
+
+	if cluster.Replica() != replicas[1] {
+		t.Fatal("replica should recover")
+	}
+}
+
+func TestReplicaLagConcurrent(t *testing.T) {
+
+	client := &Client{Host: "replica"}
+	client.QueryContext = func(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientQueryResult {
+		return benchQuery(1)
+	}
+
+	node := newNodeHealth(client, "replica")
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			replicaLag(context.Background(), node)
+		}()
+	}
+
+	wg.Wait()
+
+	if node.statusStmt != "SHOW REPLICA STATUS" {
+		t.Fatal("status statement should be remembered:", node.statusStmt)
+	}
+}
+
+func TestClusterBatchOptions(t *testing.T) {
+
+	primary := newTxClient(t)
+	replica := newTxClient(t)
+
+	detects := 0
+
+	primary.QueryContext = func(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientQueryResult {
+		detects++
+		if detects == 1 {
+			return &ClientQueryResult{Err: errors.New("primary is down")}
+		}
+		return benchQuery(1)
+	}
+
+	replicaQueries := 0
+
+	replica.Use(func(ctx context.Context, info *QueryInfo, next Invoker) error {
+		replicaQueries++
+		return next(ctx, info)
+	})
+
+	cluster := NewClusterClient(primary, []*Client{replica}, nil)
+	cluster.SetBatchOptions(BatchOptions{MaxRows: 3})
+
+	if primary.batch.options.MaxRows != 0 {
+		t.Fatal("cluster batch options should not change the primary client")
+	}
+
+	if options := cluster.batchOptions(); options.MaxRows != 3 || options.MaxBytes != defaultMaxAllowedPacket {
+		t.Fatalf("default packet expected when detection fails: %+v", options)
+	}
+
+	if cluster.batch.packet != 0 {
+		t.Fatal("failed detection should not be cached")
+	}
+
+	cluster.batchOptions()
+
+	if options := cluster.batchOptions(); options.MaxBytes != 1 || detects != 2 {
+		t.Fatalf("detected packet should be cached: %+v, %d detects", options, detects)
+	}
+
+	if replicaQueries != 0 {
+		t.Fatal("server variables should be detected on the primary")
+	}
+
+	tx, err := cluster.Sql.Begin()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer tx.Rollback()
+
+	if tx.batch != cluster.batch {
+		t.Fatal("transactions should use the cluster batch options")
+	}
+}
@@ -1,5 +1,137 @@
 package litedb
 
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// 错误分类.
+// ClientExecResult.Err 与 ClientQueryResult.Err 中的错误请使用 IsDuplicateKey 等函数判断:
+//
+//	if litedb.IsDuplicateKey(result.Err) {
+//		...
+//	}
+//
+// 服务端返回的 *mysql.MySQLError 与之前的版本一样原样返回, 可以直接断言类型, 但 errors.Is 无法将其与分类匹配;
+// litedb 包装的错误(*SQLError, *NetError, *BatchError)可以使用 errors.Is(err, litedb.ErrDuplicateKey)
+var (
+	ErrDuplicateKey        = errors.New("[litedb] duplicate key")
+	ErrDeadlock            = errors.New("[litedb] deadlock")
+	ErrLockWaitTimeout     = errors.New("[litedb] lock wait timeout")
+	ErrForeignKeyViolation = errors.New("[litedb] foreign key violation")
+	ErrConnectionLost      = errors.New("[litedb] connection lost")
+	ErrReadOnly            = errors.New("[litedb] read only")
+)
+
+// MySQL 错误码与错误分类的对应关系
+var mysqlErrorClass = map[uint16]error{
+	1022: ErrDuplicateKey, // ER_DUP_KEY
+	1062: ErrDuplicateKey, // ER_DUP_ENTRY
+	1586: ErrDuplicateKey, // ER_DUP_ENTRY_WITH_KEY_NAME
+
+	1213: ErrDeadlock, // ER_LOCK_DEADLOCK
+
+	1205: ErrLockWaitTimeout, // ER_LOCK_WAIT_TIMEOUT
+
+	1216: ErrForeignKeyViolation, // ER_NO_REFERENCED_ROW
+	1217: ErrForeignKeyViolation, // ER_ROW_IS_REFERENCED
+	1451: ErrForeignKeyViolation, // ER_ROW_IS_REFERENCED_2
+	1452: ErrForeignKeyViolation, // ER_NO_REFERENCED_ROW_2
+
+	1053: ErrConnectionLost, // ER_SERVER_SHUTDOWN
+	1927: ErrConnectionLost, // ER_CONNECTION_KILLED
+	2006: ErrConnectionLost, // CR_SERVER_GONE_ERROR
+	2013: ErrConnectionLost, // CR_SERVER_LOST
+	4031: ErrConnectionLost, // ER_CLIENT_INTERACTION_TIMEOUT
+
+	1290: ErrReadOnly, // ER_OPTION_PREVENTS_STATEMENT (--read-only)
+	1792: ErrReadOnly, // ER_CANT_EXECUTE_IN_READ_ONLY_TRANSACTION
+	1836: ErrReadOnly, // ER_READ_ONLY_MODE
+}
+
+// 返回 err 所属的错误分类, 无法分类时返回 nil
+func classifyError(err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	// context.DeadlineExceeded 同样实现了 net.Error, 但超时与取消不代表连接断开
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return nil
+	}
+
+	var me *mysql.MySQLError
+
+	if errors.As(err, &me) {
+		return mysqlErrorClass[me.Number]
+	}
+
+	var ne net.Error
+
+	if errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, driver.ErrBadConn) || errors.As(err, &ne) {
+		return ErrConnectionLost
+	}
+
+	return nil
+}
+
+// IsDuplicateKey 唯一键或主键冲突
+func IsDuplicateKey(err error) bool {
+	return classifyError(err) == ErrDuplicateKey
+}
+
+// IsDeadlock 死锁, 事务已被回滚
+func IsDeadlock(err error) bool {
+	return classifyError(err) == ErrDeadlock
+}
+
+// IsLockWaitTimeout 等待行锁超时
+func IsLockWaitTimeout(err error) bool {
+	return classifyError(err) == ErrLockWaitTimeout
+}
+
+// IsForeignKeyViolation 违反外键约束
+func IsForeignKeyViolation(err error) bool {
+	return classifyError(err) == ErrForeignKeyViolation
+}
+
+// IsConnectionLost 连接已断开或不可用
+func IsConnectionLost(err error) bool {
+	return classifyError(err) == ErrConnectionLost
+}
+
+// IsReadOnly 数据库或事务处于只读状态
+func IsReadOnly(err error) bool {
+	return classifyError(err) == ErrReadOnly
+}
+
+// 将驱动返回的错误包装为 litedb 的错误类型, 已经包装过的错误原样返回.
+// *mysql.MySQLError 不包装, 调用方的 err.(*mysql.MySQLError) 类型断言仍然有效
+func wrapError(err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	switch err.(type) {
+	case *SQLError, *NetError, *ReflectError, *EmptyRowsError, *BatchError, *StaleObjectError, *mysql.MySQLError:
+		return err
+	}
+
+	if classifyError(err) == ErrConnectionLost {
+		return &NetError{s: err.Error(), err: err}
+	}
+
+	return &SQLError{s: err.Error(), err: err}
+}
+
 //EmptyRowsError 未发现行
 type EmptyRowsError struct {
 }
@@ -8,9 +140,15 @@ func (err *EmptyRowsError) Error() string {
 	return "[litedb] Rows Not Found"
 }
 
+// Unwrap 使 errors.Is(err, sql.ErrNoRows) 成立
+func (err *EmptyRowsError) Unwrap() error {
+	return sql.ErrNoRows
+}
+
 //NetError 网络错误
 type NetError struct {
-	s string
+	s   string
+	err error
 }
 
 func (err *NetError) Error() string {
@@ -19,9 +157,18 @@ func (err *NetError) Error() string {
 
 }
 
+func (err *NetError) Unwrap() error {
+	return err.err
+}
+
+func (err *NetError) Is(target error) bool {
+	return target != nil && classifyError(err.err) == target
+}
+
 //SQLError 错误
 type SQLError struct {
-	s string
+	s   string
+	err error
 }
 
 func (err *SQLError) Error() string {
@@ -29,12 +176,56 @@ func (err *SQLError) Error() string {
 	return "[litedb] SQL Error:" + err.s
 }
 
+func (err *SQLError) Unwrap() error {
+	return err.err
+}
+
+func (err *SQLError) Is(target error) bool {
+	return target != nil && classifyError(err.err) == target
+}
+
 //ReflectError 反射阶段错误
 type ReflectError struct {
-	s string
+	s   string
+	err error
 }
 
 func (err *ReflectError) Error() string {
 
 	return "[litedb] Reflect Error:" + err.s
 }
+
+func (err *ReflectError) Unwrap() error {
+	return err.err
+}
+
+// BatchError 批量操作拆分为多条语句执行时, 其中一条语句失败
+type BatchError struct {
+	Table        string // 失败语句的物理表名
+	Chunk        int    // 失败语句的序号, 从 0 开始
+	Chunks       int    // 语句总数
+	RowsAffected int64  // 之前的语句影响的行数, 在事务中执行时这些修改已被回滚
+	err          error
+}
+
+func (err *BatchError) Error() string {
+	return fmt.Sprintf("[litedb] Batch Error: chunk %d/%d on `%s`: %s", err.Chunk+1, err.Chunks, err.Table, err.err)
+}
+
+func (err *BatchError) Unwrap() error {
+	return err.err
+}
+
+func (err *BatchError) Is(target error) bool {
+	return target != nil && classifyError(err.err) == target
+}
+
+// StaleObjectError UpdateWithVersion 没有更新任何行, 数据已被其他人修改或已被删除
+type StaleObjectError struct {
+	Table   string
+	Version int64 // 更新时使用的版本号
+}
+
+func (err *StaleObjectError) Error() string {
+	return fmt.Sprintf("[litedb] Stale Object Error: `%s` version %d has been modified or deleted", err.Table, err.Version)
+}
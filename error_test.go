@@ -0,0 +1,102 @@
+package litedb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestErrorClassification(t *testing.T) {
+
+	cases := []struct {
+		err    error
+		class  error
+		isFunc func(error) bool
+	}{
+		{&mysql.MySQLError{Number: 1062}, ErrDuplicateKey, IsDuplicateKey},
+		{&mysql.MySQLError{Number: 1213}, ErrDeadlock, IsDeadlock},
+		{&mysql.MySQLError{Number: 1205}, ErrLockWaitTimeout, IsLockWaitTimeout},
+		{&mysql.MySQLError{Number: 1452}, ErrForeignKeyViolation, IsForeignKeyViolation},
+		{&mysql.MySQLError{Number: 2013}, ErrConnectionLost, IsConnectionLost},
+		{driver.ErrBadConn, ErrConnectionLost, IsConnectionLost},
+		{&mysql.MySQLError{Number: 1290}, ErrReadOnly, IsReadOnly},
+	}
+
+	for _, c := range cases {
+
+		// 驱动错误经过其他错误包装后才会被 litedb 包装
+		wrapped := wrapError(fmt.Errorf("exec: %w", c.err))
+
+		if !c.isFunc(c.err) || !c.isFunc(wrapped) || !c.isFunc(wrapError(c.err)) {
+			t.Fatalf("%v: not classified as %v", c.err, c.class)
+		}
+
+		if !errors.Is(wrapped, c.class) {
+			t.Fatalf("%v: errors.Is(%v) = false", wrapped, c.class)
+		}
+
+		if errors.Is(wrapped, ErrDuplicateKey) != (c.class == ErrDuplicateKey) {
+			t.Fatalf("%v: unexpected match with ErrDuplicateKey", wrapped)
+		}
+
+		if !errors.Is(wrapped, c.err) {
+			t.Fatalf("%v: cause is not reachable through Unwrap", wrapped)
+		}
+	}
+
+	// 与之前的版本一样, 服务端错误可以直接断言类型
+	if me, ok := wrapError(&mysql.MySQLError{Number: 1062}).(*mysql.MySQLError); !ok || me.Number != 1062 {
+		t.Fatal("*mysql.MySQLError should not be wrapped")
+	}
+
+	var me *mysql.MySQLError
+
+	if !errors.As(wrapError(fmt.Errorf("exec: %w", &mysql.MySQLError{Number: 1062})), &me) || me.Number != 1062 {
+		t.Fatal("errors.As can not reach *mysql.MySQLError")
+	}
+
+	if !errors.Is(&BatchError{err: &mysql.MySQLError{Number: 1213}}, ErrDeadlock) {
+		t.Fatal("BatchError should match the class of the failed statement")
+	}
+
+	if !errors.Is(&EmptyRowsError{}, sql.ErrNoRows) {
+		t.Fatal("EmptyRowsError should match sql.ErrNoRows")
+	}
+
+	if IsDuplicateKey(errors.New("Duplicate entry")) || classifyError(nil) != nil {
+		t.Fatal("unexpected classification")
+	}
+}
+
+func TestContextErrorClassification(t *testing.T) {
+
+	for _, err := range []error{context.DeadlineExceeded, context.Canceled, fmt.Errorf("query: %w", context.DeadlineExceeded)} {
+
+		if IsConnectionLost(err) || classifyError(err) != nil {
+			t.Fatalf("%v: context error should not be classified", err)
+		}
+
+		wrapped := wrapError(err)
+
+		if _, ok := wrapped.(*SQLError); !ok {
+			t.Fatalf("%v: wrapped as %T, want *SQLError", err, wrapped)
+		}
+
+		if !errors.Is(wrapped, err) || errors.Is(wrapped, ErrConnectionLost) {
+			t.Fatalf("%v: unexpected errors.Is result", wrapped)
+		}
+	}
+
+	if errors.Is(&NetError{s: "ping error", err: context.DeadlineExceeded}, ErrConnectionLost) {
+		t.Fatal("NetError should be classified by its cause")
+	}
+
+	if !errors.Is(&NetError{s: "bad conn", err: driver.ErrBadConn}, ErrConnectionLost) {
+		t.Fatal("NetError caused by a bad connection should match ErrConnectionLost")
+	}
+}
@@ -0,0 +1,280 @@
+package litedb
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 健康检查配置
+type HealthCheckConfig struct {
+	Interval time.Duration // 检查间隔, 默认 5 秒
+	Timeout  time.Duration // 单次检查超时, 默认 1 秒
+
+	// 从库允许的最大复制延迟, 为 0 时不检查复制状态.
+	// 检查时依次尝试 SHOW REPLICA STATUS 与 SHOW SLAVE STATUS
+	MaxLag time.Duration
+
+	FailThreshold    int // 连续失败多少次后移出负载均衡, 默认 1
+	RecoverThreshold int // 连续成功多少次后重新加入负载均衡, 默认 1
+}
+
+func (this HealthCheckConfig) withDefaults() HealthCheckConfig {
+
+	if this.Interval <= 0 {
+		this.Interval = 5 * time.Second
+	}
+
+	if this.Timeout <= 0 {
+		this.Timeout = time.Second
+	}
+
+	if this.FailThreshold < 1 {
+		this.FailThreshold = 1
+	}
+
+	if this.RecoverThreshold < 1 {
+		this.RecoverThreshold = 1
+	}
+
+	return this
+}
+
+// 节点的健康状态, 用于监控展示
+type NodeState struct {
+	Role      string // primary 或 replica
+	Host      string
+	Port      uint32
+	Healthy   bool
+	Lag       time.Duration // 复制延迟, 未检查或未知时为 -1
+	LastCheck time.Time
+	LastError error
+}
+
+type nodeHealth struct {
+	client    *Client
+	state     NodeState
+	failures  int
+	successes int
+
+	// 该节点可用的复制状态查询语句.
+	// 手动调用的 CheckHealth 可能与后台检查同时执行, 因此单独加锁
+	mu         sync.Mutex
+	statusStmt string
+}
+
+func newNodeHealth(client *Client, role string) *nodeHealth {
+	return &nodeHealth{
+		client: client,
+		state: NodeState{
+			Role:    role,
+			Host:    client.Host,
+			Port:    client.Port,
+			Healthy: true,
+			Lag:     -1,
+		},
+	}
+}
+
+// 主库以及全部从库的健康状态
+func (this *ClusterClient) NodeStates() []NodeState {
+
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	states := make([]NodeState, 0, len(this.nodes))
+
+	for _, node := range this.nodes {
+		states = append(states, node.state)
+	}
+
+	return states
+}
+
+// 启动后台健康检查.
+// 失败或复制延迟超过 MaxLag 的从库会被移出负载均衡, 恢复后重新加入.
+// 所有从库都不健康时查询会发送到主库
+func (this *ClusterClient) StartHealthCheck(config HealthCheckConfig) {
+
+	config = config.withDefaults()
+
+	this.StopHealthCheck()
+
+	stop := make(chan struct{})
+
+	this.mu.Lock()
+	this.stop = stop
+	this.mu.Unlock()
+
+	go func() {
+
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+
+		for {
+			this.CheckHealth(config)
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// 停止后台健康检查
+func (this *ClusterClient) StopHealthCheck() {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.stop != nil {
+		close(this.stop)
+		this.stop = nil
+	}
+}
+
+// 立即对所有节点进行一次健康检查
+func (this *ClusterClient) CheckHealth(config HealthCheckConfig) {
+
+	config = config.withDefaults()
+
+	var wg sync.WaitGroup
+
+	lags := make([]time.Duration, len(this.nodes))
+	errs := make([]error, len(this.nodes))
+
+	for i, node := range this.nodes {
+
+		wg.Add(1)
+
+		go func(i int, node *nodeHealth) {
+			defer wg.Done()
+			lags[i], errs[i] = this.checkNode(node, config)
+		}(i, node)
+	}
+
+	wg.Wait()
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	healthy := make([]*Client, 0, len(this.replicas))
+
+	for i, node := range this.nodes {
+
+		node.state.LastCheck = time.Now()
+		node.state.LastError = errs[i]
+		node.state.Lag = lags[i]
+
+		if errs[i] != nil {
+			node.successes = 0
+			node.failures++
+			if node.state.Healthy && node.failures >= config.FailThreshold {
+				node.state.Healthy = false
+				if Debug {
+					log.Println("[Litedb Debug] node unhealthy:", node.state.Role, node.state.Host, errs[i])
+				}
+			}
+		} else {
+			node.failures = 0
+			node.successes++
+			if !node.state.Healthy && node.successes >= config.RecoverThreshold {
+				node.state.Healthy = true
+				if Debug {
+					log.Println("[Litedb Debug] node recovered:", node.state.Role, node.state.Host)
+				}
+			}
+		}
+
+		if node.state.Role == "replica" && node.state.Healthy {
+			healthy = append(healthy, node.client)
+		}
+	}
+
+	this.healthy = healthy
+}
+
+func (this *ClusterClient) checkNode(node *nodeHealth, config HealthCheckConfig) (time.Duration, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	if err := node.client.PingContext(ctx); err != nil {
+		return -1, err
+	}
+
+	if node.state.Role != "replica" || config.MaxLag <= 0 {
+		return -1, nil
+	}
+
+	lag, err := replicaLag(ctx, node)
+
+	if err != nil {
+		return -1, err
+	}
+
+	if lag > config.MaxLag {
+		return lag, &SQLError{s: "replication lag " + lag.String() + " exceeds " + config.MaxLag.String()}
+	}
+
+	return lag, nil
+}
+
+// 查询从库的复制延迟.
+// MySQL 8.0.22 之后使用 SHOW REPLICA STATUS, 之前的版本使用 SHOW SLAVE STATUS
+func replicaLag(ctx context.Context, node *nodeHealth) (time.Duration, error) {
+
+	stmts := []string{"SHOW REPLICA STATUS", "SHOW SLAVE STATUS"}
+
+	node.mu.Lock()
+	if node.statusStmt != "" {
+		stmts = []string{node.statusStmt}
+	}
+	node.mu.Unlock()
+
+	var rows []map[string]string
+	var err error
+
+	for _, stmt := range stmts {
+
+		rows, err = node.client.QueryContext(ctx, stmt).ToMap()
+
+		if err == nil {
+			node.mu.Lock()
+			node.statusStmt = stmt
+			node.mu.Unlock()
+			break
+		}
+	}
+
+	if err != nil {
+		return -1, err
+	}
+
+	if len(rows) < 1 {
+		return -1, &SQLError{s: "replication is not configured"}
+	}
+
+	seconds, ok := rows[0]["Seconds_Behind_Source"]
+
+	if !ok {
+		seconds = rows[0]["Seconds_Behind_Master"]
+	}
+
+	// NULL 表示复制线程没有运行
+	if len(seconds) < 1 {
+		return -1, &SQLError{s: "replication is not running"}
+	}
+
+	n, err := strconv.ParseInt(seconds, 10, 64)
+
+	if err != nil {
+		return -1, err
+	}
+
+	return time.Duration(n) * time.Second, nil
+}
@@ -0,0 +1,158 @@
+package litedb
+
+import (
+	"context"
+	"reflect"
+)
+
+// 生命周期钩子.
+// 传入语法糖的 struct(或 struct 指针)实现了以下接口时会被自动调用, 需要修改字段时请使用指针接收者并传入指针.
+// 钩子的参数为执行语句的 Sql, 在 Transaction 上调用时即为该事务, 钩子中的查询与写入会在同一个事务中执行.
+//
+// Before* 返回错误时不执行语句, 该错误作为结果的 Err 返回;
+// After* 在语句执行成功后调用, 返回的错误同样作为结果的 Err 返回, 此时语句已经执行, 是否回滚由调用方决定.
+//
+//	func (this *Order) BeforeInsert(db *litedb.Sql) error {
+//		if this.Amount <= 0 {
+//			return errors.New("invalid amount")
+//		}
+//		return nil
+//	}
+
+// Insert, InsertOrUpdate 以及 BatchInsert 等批量插入之前调用
+type BeforeInsertHook interface {
+	BeforeInsert(db *Sql) error
+}
+
+// 插入成功之后调用, 自增主键已经回填
+type AfterInsertHook interface {
+	AfterInsert(db *Sql) error
+}
+
+// Update, UpdateFields, UpdateWithVersion, InsertOrUpdate 以及 BatchInsertOrUpdate 之前调用
+type BeforeUpdateHook interface {
+	BeforeUpdate(db *Sql) error
+}
+
+// 更新成功之后调用
+type AfterUpdateHook interface {
+	AfterUpdate(db *Sql) error
+}
+
+// DeleteModel 删除之前调用
+type BeforeDeleteHook interface {
+	BeforeDelete(db *Sql) error
+}
+
+// FirstToStruct, ToStruct, FirstScanToStruct, ScanToStruct, RowIterator.Scan 与 Each 填充每一行之后调用.
+// 直接构造的 ClientQueryResult 没有对应的 Sql, 此时 db 为 nil
+type AfterFindHook interface {
+	AfterFind(db *Sql) error
+}
+
+type hookKind int
+
+const (
+	hookBeforeInsert hookKind = iota
+	hookAfterInsert
+	hookBeforeUpdate
+	hookAfterUpdate
+	hookBeforeDelete
+	hookAfterFind
+)
+
+// 依次调用 v 实现的钩子, 遇到错误时停止
+func runHooks(db *Sql, v interface{}, kinds ...hookKind) error {
+
+	for _, kind := range kinds {
+
+		var err error
+
+		switch kind {
+		case hookBeforeInsert:
+			if h, ok := v.(BeforeInsertHook); ok {
+				err = h.BeforeInsert(db)
+			}
+		case hookAfterInsert:
+			if h, ok := v.(AfterInsertHook); ok {
+				err = h.AfterInsert(db)
+			}
+		case hookBeforeUpdate:
+			if h, ok := v.(BeforeUpdateHook); ok {
+				err = h.BeforeUpdate(db)
+			}
+		case hookAfterUpdate:
+			if h, ok := v.(AfterUpdateHook); ok {
+				err = h.AfterUpdate(db)
+			}
+		case hookBeforeDelete:
+			if h, ok := v.(BeforeDeleteHook); ok {
+				err = h.BeforeDelete(db)
+			}
+		case hookAfterFind:
+			if h, ok := v.(AfterFindHook); ok {
+				err = h.AfterFind(db)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 对 slice 或 array 的每个元素调用钩子, 可寻址的元素以指针调用
+func runListHooks(db *Sql, vs interface{}, kinds ...hookKind) error {
+
+	p := reflect.ValueOf(vs)
+
+	if p.Kind() == reflect.Ptr {
+		p = p.Elem()
+	}
+
+	if p.Kind() != reflect.Slice && p.Kind() != reflect.Array {
+		return nil
+	}
+
+	for i := 0; i < p.Len(); i++ {
+
+		v := p.Index(i)
+
+		if v.CanAddr() && v.Kind() != reflect.Ptr {
+			v = v.Addr()
+		}
+
+		if err := runHooks(db, v.Interface(), kinds...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 语句执行成功后调用 After* 钩子, 钩子的错误写入 r.Err
+func (this *Sql) afterHooks(r *ClientExecResult, v interface{}, kinds ...hookKind) *ClientExecResult {
+
+	if r.Err == nil {
+		r.Err = runHooks(this, v, kinds...)
+	}
+
+	return r
+}
+
+// 同 Delete, 删除之前调用 v 的 BeforeDelete 钩子, v 只用于调用钩子, 删除的条件由 whereFmt 决定
+func (this *Sql) DeleteModel(table string, v interface{}, whereFmt string, whereValue ...interface{}) *ClientExecResult {
+	return this.DeleteModelContext(context.Background(), table, v, whereFmt, whereValue...)
+}
+
+// DeleteModelContext 同 DeleteModel, 使用 ctx 控制语句的取消与超时
+func (this *Sql) DeleteModelContext(ctx context.Context, table string, v interface{}, whereFmt string, whereValue ...interface{}) *ClientExecResult {
+
+	if err := runHooks(this, v, hookBeforeDelete); err != nil {
+		return &ClientExecResult{Err: err}
+	}
+
+	return this.DeleteContext(ctx, table, whereFmt, whereValue...)
+}
@@ -0,0 +1,142 @@
+package litedb
+
+import (
+	"context"
+	"time"
+)
+
+// 被拦截的操作类型
+type QueryOp string
+
+const (
+	OpExec     QueryOp = "exec"
+	OpQuery    QueryOp = "query"
+	OpBegin    QueryOp = "begin"
+	OpCommit   QueryOp = "commit"
+	OpRollback QueryOp = "rollback"
+)
+
+// 一次数据库操作的信息.
+// 调用 next 之前可以修改 SQL 与 Args(例如追加用于追踪的注释), 调用 next 之后可以读取执行结果
+type QueryInfo struct {
+	Op            QueryOp
+	SQL           string // begin/commit/rollback 为空, 嵌套事务的保存点语句以 exec 的形式单独拦截
+	Args          []interface{}
+	InTransaction bool // 是否在事务中执行, begin 表示开启嵌套事务
+
+	// 以下字段由 next 填写
+	Start        time.Time
+	Duration     time.Duration
+	RowsAffected int64 // 只对 exec 有效
+	Err          error
+}
+
+// 执行拦截链中的下一环, 最后一环执行实际的操作
+type Invoker func(ctx context.Context, info *QueryInfo) error
+
+// 拦截器, 可以用于日志、监控、链路追踪、审计以及故障注入:
+//
+//	client.Use(func(ctx context.Context, info *litedb.QueryInfo, next litedb.Invoker) error {
+//		err := next(ctx, info)
+//		log.Println(info.Op, info.SQL, info.Duration, info.RowsAffected, err)
+//		return err
+//	})
+//
+// 不调用 next 而直接返回错误时操作不会执行, 该错误作为操作的结果返回;
+// 不调用 next 却返回 nil 时操作同样不会执行, 结果的错误为 SQLError.
+// commit/rollback 的 ctx 为开启事务时的 ctx
+type Interceptor func(ctx context.Context, info *QueryInfo, next Invoker) error
+
+// 添加拦截器, 先添加的在外层.
+// 由该 Sql 开启的事务继承当前的拦截器, 之后在事务上添加的拦截器只对该事务生效.
+// 请在使用之前完成设置, Use 与正在执行的操作之间没有同步
+func (this *Sql) Use(interceptors ...Interceptor) {
+	// 限制容量使 append 总是复制, 事务与开启它的 Sql 不会共享底层数组
+	this.interceptors = append(this.interceptors[:len(this.interceptors):len(this.interceptors)], interceptors...)
+}
+
+// 依次经过全部拦截器后执行 call
+func (this *Sql) intercept(ctx context.Context, info *QueryInfo, call Invoker) error {
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	called := false
+
+	invoker := func(ctx context.Context, info *QueryInfo) error {
+		called = true
+		info.Start = time.Now()
+		info.Err = call(ctx, info)
+		info.Duration = time.Since(info.Start)
+		return info.Err
+	}
+
+	for i := len(this.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := this.interceptors[i], Invoker(invoker)
+		invoker = func(ctx context.Context, info *QueryInfo) error {
+			return interceptor(ctx, info, next)
+		}
+	}
+
+	err := invoker(ctx, info)
+
+	// 操作没有执行却返回 nil 时调用方拿不到结果, 按错误处理
+	if err == nil && !called {
+		err = &SQLError{s: "interceptor did not invoke next for " + string(info.Op)}
+		info.Err = err
+	}
+
+	return err
+}
+
+func (this *Sql) interceptExec(ctx context.Context, info *QueryInfo, exec func(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientExecResult) *ClientExecResult {
+
+	if len(this.interceptors) < 1 {
+		return exec(ctx, info.SQL, info.Args...)
+	}
+
+	result := new(ClientExecResult)
+
+	err := this.intercept(ctx, info, func(ctx context.Context, info *QueryInfo) error {
+
+		result = exec(ctx, info.SQL, info.Args...)
+
+		if result.Err == nil && result.Result != nil {
+			info.RowsAffected, _ = result.Result.RowsAffected()
+		}
+
+		return result.Err
+	})
+
+	if err != nil {
+		result.Err = err
+	}
+
+	return result
+}
+
+func (this *Sql) interceptQuery(ctx context.Context, info *QueryInfo, query func(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ClientQueryResult) *ClientQueryResult {
+
+	if len(this.interceptors) < 1 {
+		return query(ctx, info.SQL, info.Args...)
+	}
+
+	result := &ClientQueryResult{db: this}
+
+	err := this.intercept(ctx, info, func(ctx context.Context, info *QueryInfo) error {
+		result = query(ctx, info.SQL, info.Args...)
+		return result.Err
+	})
+
+	if err != nil {
+		// 拦截器在查询成功后返回错误时, 调用方不会再读取结果集
+		if result.Rows != nil {
+			result.Rows.Close()
+			result.Rows = nil
+		}
+		result.Err = err
+	}
+
+	return result
+}
@@ -0,0 +1,176 @@
+package litedb
+
+import (
+	"database/sql"
+	"reflect"
+)
+
+// RowIterator 逐行读取结果集, 不会将整个结果集缓存在内存中.
+// 用法与 sql.Rows 类似:
+//
+//	it := client.Query("SELECT * FROM person").Iterator()
+//	defer it.Close()
+//
+//	for it.Next() {
+//		var p Person
+//		if err := it.Scan(&p); err != nil {
+//			return err
+//		}
+//	}
+//
+//	return it.Err()
+type RowIterator struct {
+	result  *ClientQueryResult
+	columns []*sql.ColumnType
+	plan    *scanPlan // 上一次 Scan 使用的映射, struct 类型相同时复用
+	ready   bool      // 当前行可以读取
+	err     error
+	closed  bool
+}
+
+// Iterator 返回结果集的逐行迭代器.
+// 迭代结束或出错时 Rows 会被自动关闭
+func (this *ClientQueryResult) Iterator() *RowIterator {
+
+	it := &RowIterator{result: this}
+
+	if this.Err != nil {
+		it.err = wrapError(this.Err)
+		it.closed = true
+	}
+
+	return it
+}
+
+// Next 读取下一行, 没有更多数据或出错时返回 false
+func (this *RowIterator) Next() bool {
+
+	if this.closed {
+		return false
+	}
+
+	rows := this.result.Rows
+
+	if this.columns == nil {
+		columns, err := rows.ColumnTypes()
+		if err != nil {
+			this.err = wrapError(err)
+			this.Close()
+			return false
+		}
+		this.columns = columns
+	}
+
+	this.ready = false
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			this.err = wrapError(err)
+		}
+		this.Close()
+		return false
+	}
+
+	this.ready = true
+	return true
+}
+
+// Scan 将当前行直接扫描到 struct 指针中, 映射规则与错误处理同 ScanToStruct, 扫描之后调用 AfterFind 钩子
+func (this *RowIterator) Scan(v interface{}) error {
+
+	if !this.ready {
+		return &ReflectError{s: "Scan called without calling Next"}
+	}
+
+	p := reflect.ValueOf(v)
+
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return &ReflectError{s: "store struct is nil-pointer"}
+	}
+
+	if this.plan == nil || this.plan.typ != p.Elem().Type() {
+		plan, err := newScanPlan(p.Elem().Type(), this.columns)
+		if err != nil {
+			return err
+		}
+		this.plan = plan
+	}
+
+	if err := this.result.Rows.Scan(this.plan.dests(p.Elem())...); err != nil {
+		return wrapError(err)
+	}
+
+	return runHooks(this.result.db, v, hookAfterFind)
+}
+
+// ScanMap 将当前行转换为 map, NULL 值转换为空字符串
+func (this *RowIterator) ScanMap() (map[string]string, error) {
+
+	if !this.ready {
+		return nil, &ReflectError{s: "ScanMap called without calling Next"}
+	}
+
+	values := make([]sql.NullString, len(this.columns))
+	dests := make([]interface{}, len(values))
+
+	for i := range values {
+		dests[i] = &values[i]
+	}
+
+	if err := this.result.Rows.Scan(dests...); err != nil {
+		return nil, wrapError(err)
+	}
+
+	ret := make(map[string]string, len(values))
+
+	for i, col := range this.columns {
+		ret[col.Name()] = values[i].String
+	}
+
+	return ret, nil
+}
+
+// Err 返回迭代过程中遇到的错误
+func (this *RowIterator) Err() error {
+	return this.err
+}
+
+// Close 关闭结果集, 可以重复调用
+func (this *RowIterator) Close() error {
+
+	if this.closed {
+		return nil
+	}
+
+	this.closed = true
+	this.ready = false
+
+	return this.result.Rows.Close()
+}
+
+// Each 逐行将结果集映射为 T 并调用 fn, T 必须是使用 db tag 的 struct 类型, *T 实现的 AfterFind 钩子在调用 fn 之前执行.
+// fn 返回错误时停止迭代并返回该错误.无论如何 Rows 都会被关闭
+//
+//	err := litedb.Each(client.Query("SELECT * FROM person"), func(p Person) error {
+//		return export(p)
+//	})
+func Each[T any](result *ClientQueryResult, fn func(row T) error) error {
+
+	it := result.Iterator()
+	defer it.Close()
+
+	for it.Next() {
+
+		var row T
+
+		if err := it.Scan(&row); err != nil {
+			return err
+		}
+
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+
+	return it.Err()
+}
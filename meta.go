@@ -0,0 +1,272 @@
+package litedb
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// struct 的映射信息.
+// 对每个类型只解析一次 db tag 与字段的编解码方式, 之后的 Insert/Update/ToStruct 等操作共享该结果
+type structMeta struct {
+	typ        reflect.Type
+	fields     []*fieldMeta          // 匿名 struct 已展开, 同名字段只保留层级较浅的一个
+	columns    map[string]*fieldMeta // 字段名到字段的映射
+	auto       *fieldMeta            // 自增主键, 没有时为 nil
+	version    *fieldMeta            // 乐观锁版本号, 没有时为 nil
+	softDelete *fieldMeta            // 软删除标记, 没有时为 nil
+
+	updateTime bool // 是否有 autoUpdateTime 字段
+}
+
+// 单个字段的映射信息
+type fieldMeta struct {
+	column string
+	index  []int // 相对于根 struct 的索引路径
+	typ    reflect.Type
+	viaPtr bool // 索引路径是否经过匿名指针
+	encode fieldEncoder
+	decode fieldDecoder
+
+	pk      bool // 主键, Update 与 ON DUPLICATE KEY UPDATE 不更新该字段
+	auto    bool // 由数据库生成的自增字段, 插入时为零值则不写入
+	version bool // 乐观锁版本号, 见 Sql.UpdateWithVersion
+
+	// 插入时为零值则写入 Clock() 的时间, autoCreateTime 在更新时不写入, autoUpdateTime 在更新时总是写入.
+	// 字段类型可以是 time.Time, *time.Time, sql.NullTime 或表示 unix 时间戳(秒)的整数
+	createTime bool
+	updateTime bool
+	omitempty  bool // 零值不写入
+	readonly   bool // 只读, 例如生成列, 只在查询时映射
+	json       bool // 以 JSON 格式读写
+
+	// 软删除标记, 0 表示不是软删除字段. 插入时零值写为未删除, Update 与 ON DUPLICATE KEY UPDATE 不更新该字段,
+	// 见 Sql.SetSoftDeleteModel
+	softDelete SoftDeleteKind
+
+	// 插入时零值的处理方式: default 表示不写入, 使用数据库的默认值;
+	// default=xxx 表示写入 xxx
+	hasDefault   bool
+	defaultValue *string
+}
+
+// 该字段不能出现在 UPDATE 的 SET 中
+func (this *fieldMeta) key() bool {
+	return this.pk || this.auto
+}
+
+// Update 与 ON DUPLICATE KEY UPDATE 默认不更新的字段
+func (this *fieldMeta) skipUpdate() bool {
+	return this.key() || this.createTime || this.softDelete != 0
+}
+
+// 插入时零值需要特殊处理的字段
+func (this *fieldMeta) trackZero() bool {
+	return this.auto || this.hasDefault || this.createTime || this.updateTime || this.softDelete != 0
+}
+
+var structMetaCache sync.Map // map[reflect.Type]*structMeta
+
+// 取得 struct 类型的映射信息, t 必须是 struct 类型
+func getStructMeta(t reflect.Type) *structMeta {
+
+	if meta, ok := structMetaCache.Load(t); ok {
+		return meta.(*structMeta)
+	}
+
+	meta := &structMeta{typ: t, columns: make(map[string]*fieldMeta)}
+
+	depth := make(map[string]int)
+	collectFieldMeta(meta, depth, t, nil, false, "")
+
+	for _, f := range meta.fields {
+		meta.columns[f.column] = f
+		if f.auto && meta.auto == nil {
+			meta.auto = f
+		}
+		if f.version && meta.version == nil {
+			meta.version = f
+		}
+		if f.updateTime {
+			meta.updateTime = true
+		}
+		if f.softDelete != 0 && meta.softDelete == nil {
+			meta.softDelete = f
+		}
+	}
+
+	actual, _ := structMetaCache.LoadOrStore(t, meta)
+	return actual.(*structMeta)
+}
+
+// 按字段声明顺序收集字段, 匿名 struct 以及标记为 inline 的 struct 在声明的位置展开,
+// 展开后的字段名加上 prefix 选项指定的前缀.
+// 同名字段外层优先, 同一层级后声明的优先
+func collectFieldMeta(meta *structMeta, depth map[string]int, t reflect.Type, parent []int, viaPtr bool, prefix string) {
+
+	level := len(parent)
+
+	for i := 0; i < t.NumField(); i++ {
+
+		field := t.Field(i)
+		tag, opts := parseTag(field.Tag.Get("db"))
+
+		if field.Anonymous || opts.has("inline") {
+
+			ft := field.Type
+			ptr := false
+
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+				ptr = true
+			}
+
+			if tag != "-" && ft.Kind() == reflect.Struct && (field.Anonymous || field.PkgPath == "") {
+				p, _ := opts.value("prefix")
+				collectFieldMeta(meta, depth, ft, appendIndex(parent, i), viaPtr || ptr, prefix+p)
+			}
+
+			continue
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if len(tag) < 1 || tag == "-" {
+			continue
+		}
+
+		tag = prefix + tag
+
+		f := &fieldMeta{
+			column:     tag,
+			index:      appendIndex(parent, i),
+			typ:        field.Type,
+			viaPtr:     viaPtr,
+			encode:     encoderOf(field.Type),
+			decode:     decoderOf(field.Type),
+			pk:         opts.has("pk"),
+			auto:       opts.has("auto"),
+			version:    opts.has("version"),
+			omitempty:  opts.has("omitempty"),
+			readonly:   opts.has("readonly"),
+			json:       opts.has("json"),
+			createTime: opts.has("autoCreateTime"),
+			updateTime: opts.has("autoUpdateTime"),
+		}
+
+		if f.json {
+			f.encode = jsonEncoder
+			f.decode = jsonDecoder
+		}
+
+		if v, ok := opts.value("softDelete"); ok || opts.has("softDelete") {
+			f.softDelete = softDeleteKindOf(field.Type, v)
+		}
+
+		if v, ok := opts.value("default"); ok {
+			f.hasDefault = true
+			f.defaultValue = &v
+		} else if opts.has("default") {
+			f.hasDefault = true
+		}
+
+		if d, ok := depth[tag]; ok {
+
+			if d < level {
+				continue
+			}
+
+			for j, old := range meta.fields {
+				if old.column == tag {
+					meta.fields = append(meta.fields[:j], meta.fields[j+1:]...)
+					break
+				}
+			}
+		}
+
+		depth[tag] = level
+		meta.fields = append(meta.fields, f)
+	}
+}
+
+// db tag 中字段名之后的选项
+type tagOptions []string
+
+func (this tagOptions) has(name string) bool {
+
+	for _, opt := range this {
+		if opt == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 形如 name=value 的选项
+func (this tagOptions) value(name string) (string, bool) {
+
+	for _, opt := range this {
+		if strings.HasPrefix(opt, name+"=") {
+			return opt[len(name)+1:], true
+		}
+	}
+
+	return "", false
+}
+
+// db tag 的格式为 "字段名,选项,选项...", 如 db:"id,pk,auto".
+// 支持的选项: pk, auto, version, omitempty, readonly, json, default, default=xxx, autoCreateTime, autoUpdateTime,
+// softDelete, softDelete=unix,
+// 以及用于 struct 字段的 inline 与 prefix=xxx, 如 db:",inline,prefix=home_"
+func parseTag(tag string) (string, tagOptions) {
+
+	parts := strings.Split(tag, ",")
+
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+
+	return parts[0], tagOptions(parts[1:])
+}
+
+func appendIndex(parent []int, i int) []int {
+	ret := make([]int, len(parent)+1)
+	copy(ret, parent)
+	ret[len(parent)] = i
+	return ret
+}
+
+// 按索引路径取得字段, 途经的 nil 匿名指针会被分配
+func fieldByIndexAlloc(p reflect.Value, index []int) reflect.Value {
+
+	for i, x := range index {
+		if i > 0 && p.Kind() == reflect.Ptr {
+			if p.IsNil() {
+				p.Set(reflect.New(p.Type().Elem()))
+			}
+			p = p.Elem()
+		}
+		p = p.Field(x)
+	}
+
+	return p
+}
+
+// 按索引路径取得字段, 途经 nil 匿名指针时返回 false
+func fieldByIndex(p reflect.Value, index []int) (reflect.Value, bool) {
+
+	for i, x := range index {
+		if i > 0 && p.Kind() == reflect.Ptr {
+			if p.IsNil() {
+				return reflect.Value{}, false
+			}
+			p = p.Elem()
+		}
+		p = p.Field(x)
+	}
+
+	return p, true
+}
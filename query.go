@@ -0,0 +1,94 @@
+package litedb
+
+import (
+	"context"
+	"database/sql"
+)
+
+// QueryAll 执行查询并将全部结果映射为 []T.
+// 与 ClientQueryResult.ToStruct 使用同样的 db tag 映射规则,
+// 但元素类型在编译期确定, 无需预先声明容器变量:
+//
+//	persons, err := litedb.QueryAll[Person](&client.Sql, "SELECT * FROM person WHERE age > ?", 18)
+//
+// T 必须是使用 db tag 的 struct 类型.没有结果时返回空切片
+func QueryAll[T any](s *Sql, sqlFmt string, sqlValue ...interface{}) ([]T, error) {
+	return QueryAllContext[T](context.Background(), s, sqlFmt, sqlValue...)
+}
+
+// QueryAllContext 同 QueryAll, 使用 ctx 控制语句的取消与超时
+func QueryAllContext[T any](ctx context.Context, s *Sql, sqlFmt string, sqlValue ...interface{}) ([]T, error) {
+
+	out := make([]T, 0)
+
+	if err := s.queryWithContext(ctx, sqlFmt, sqlValue...).ToStruct(&out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// QueryOne 执行查询并将首行映射为 T, 映射规则同 QueryAll.
+// 没有结果时返回 EmptyRowsError
+func QueryOne[T any](s *Sql, sqlFmt string, sqlValue ...interface{}) (T, error) {
+	return QueryOneContext[T](context.Background(), s, sqlFmt, sqlValue...)
+}
+
+// QueryOneContext 同 QueryOne, 使用 ctx 控制语句的取消与超时
+func QueryOneContext[T any](ctx context.Context, s *Sql, sqlFmt string, sqlValue ...interface{}) (T, error) {
+
+	var out T
+
+	if err := s.queryWithContext(ctx, sqlFmt, sqlValue...).FirstToStruct(&out); err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return out, nil
+}
+
+// QueryScalar 执行查询并返回首行首列的值, 适用于 SELECT COUNT(*) 之类的语句, 其余的列会被忽略.
+// 类型转换由 database/sql 完成.没有结果时返回 EmptyRowsError
+func QueryScalar[T any](s *Sql, sqlFmt string, sqlValue ...interface{}) (T, error) {
+	return QueryScalarContext[T](context.Background(), s, sqlFmt, sqlValue...)
+}
+
+// QueryScalarContext 同 QueryScalar, 使用 ctx 控制语句的取消与超时
+func QueryScalarContext[T any](ctx context.Context, s *Sql, sqlFmt string, sqlValue ...interface{}) (T, error) {
+
+	var out T
+
+	result := s.queryWithContext(ctx, sqlFmt, sqlValue...)
+
+	if result.Err != nil {
+		return out, wrapError(result.Err)
+	}
+
+	defer result.Rows.Close()
+
+	if !result.Rows.Next() {
+		if err := result.Rows.Err(); err != nil {
+			return out, wrapError(err)
+		}
+		return out, &EmptyRowsError{}
+	}
+
+	columns, err := result.Rows.Columns()
+
+	if err != nil {
+		return out, wrapError(err)
+	}
+
+	dests := make([]interface{}, len(columns))
+	dests[0] = &out
+
+	for i := 1; i < len(dests); i++ {
+		dests[i] = new(sql.RawBytes)
+	}
+
+	if err := result.Rows.Scan(dests...); err != nil {
+		return out, wrapError(err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,71 @@
+package litedb
+
+import (
+	"testing"
+)
+
+// 查询由 benchDB 返回 sqlValue[0] 行数据的 Sql
+func benchSql() *Sql {
+	return &Sql{
+		Query: func(sqlFmt string, sqlValue ...interface{}) *ClientQueryResult {
+			rows, err := benchDB.Query(sqlFmt, sqlValue...)
+			return &ClientQueryResult{Rows: rows, Err: err}
+		},
+	}
+}
+
+func TestQueryAll(t *testing.T) {
+
+	rows, err := QueryAll[benchRow](benchSql(), "SELECT", "3")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 3 || rows[2].Id != 3 || rows[2].Name != "name-3" {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+
+	rows, err = QueryAll[benchRow](benchSql(), "SELECT", "0")
+
+	if err != nil || rows == nil || len(rows) != 0 {
+		t.Fatal("empty slice expected", rows, err)
+	}
+}
+
+func TestQueryOne(t *testing.T) {
+
+	row, err := QueryOne[benchRow](benchSql(), "SELECT", "2")
+
+	if err != nil || row.Id != 1 {
+		t.Fatal("first row expected", row, err)
+	}
+
+	row, err = QueryOne[benchRow](benchSql(), "SELECT", "0")
+
+	if _, ok := err.(*EmptyRowsError); !ok || row.Id != 0 {
+		t.Fatal("EmptyRowsError and zero value expected", row, err)
+	}
+}
+
+func TestQueryScalar(t *testing.T) {
+
+	// 结果集有多列时只取第一列
+	id, err := QueryScalar[int64](benchSql(), "SELECT", "2")
+
+	if err != nil || id != 1 {
+		t.Fatal("first column of first row expected", id, err)
+	}
+
+	name, err := QueryScalar[string](benchSql(), "SELECT", "1")
+
+	if err != nil || name != "1" {
+		t.Fatal("string conversion expected", name, err)
+	}
+
+	if _, err := QueryScalar[int64](benchSql(), "SELECT", "0"); err == nil {
+		t.Fatal("EmptyRowsError expected")
+	} else if _, ok := err.(*EmptyRowsError); !ok {
+		t.Fatal("EmptyRowsError expected", err)
+	}
+}
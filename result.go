@@ -3,6 +3,9 @@ package litedb
 import (
 	"database/sql"
 	"reflect"
+	"sort"
+	"strconv"
+	"time"
 )
 
 // Client.Exec 的结果
@@ -15,6 +18,8 @@ type ClientExecResult struct {
 type ClientQueryResult struct {
 	Rows *sql.Rows
 	Err  error // db error
+
+	db *Sql // 执行查询的 Sql, 传递给 AfterFind 钩子
 }
 
 // 支持struct中的字段拥有更复杂的类型.
@@ -32,10 +37,40 @@ type UnmarshalBinary interface {
 // 这个操作不进行任何类型转换.
 // 因为这里的类型转换需要一次SQL去反射字段类型.
 // 更多的时候会得不偿失.
+// NULL 值会被转换为空字符串
 func (this *ClientQueryResult) ToMap() ([]map[string]string, error) {
 
+	maps, err := this.toNullableMap()
+
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := make([]map[string]string, 0, len(maps))
+
+	for _, item := range maps {
+
+		var parsedTmp map[string]string = make(map[string]string, len(item))
+
+		for key, raw := range item {
+			if raw != nil {
+				parsedTmp[key] = *raw
+			} else {
+				parsedTmp[key] = ""
+			}
+		}
+
+		parsed = append(parsed, parsedTmp)
+	}
+
+	return parsed, nil
+}
+
+// 将结果集读取为 map, NULL 值以 nil 表示
+func (this *ClientQueryResult) toNullableMap() ([]map[string]*string, error) {
+
 	if this.Err != nil {
-		return nil, &SQLError{s: this.Err.Error()}
+		return nil, wrapError(this.Err)
 	}
 	defer func() {
 		this.Rows.Close()
@@ -44,35 +79,51 @@ func (this *ClientQueryResult) ToMap() ([]map[string]string, error) {
 	fields, err := this.Rows.Columns()
 
 	if err != nil {
-		return nil, &SQLError{s: this.Err.Error()}
+		return nil, wrapError(err)
 	}
 
-	parsed := make([]map[string]string, 0)
+	parsed := make([]map[string]*string, 0)
 
 	for this.Rows.Next() {
 
-		scanStore := make([]interface{}, 0, len(fields))
-		tempData := make(map[string]interface{}, len(fields))
+		parsedTmp, err := scanNullableRow(this.Rows, fields)
 
-		for _, field := range fields {
-			var tmp []byte
-			scanStore = append(scanStore, &tmp)
-			tempData[field] = &tmp
+		if err != nil {
+			return nil, err
 		}
 
-		err = this.Rows.Scan(scanStore...)
+		parsed = append(parsed, parsedTmp)
+	}
 
-		if err != nil {
-			return nil, &SQLError{s: this.Err.Error()}
-		}
+	if err := this.Rows.Err(); err != nil {
+		return nil, wrapError(err)
+	}
 
-		var parsedTmp map[string]string = make(map[string]string, 0)
+	return parsed, nil
+}
 
-		for key, raw := range tempData {
-			parsedTmp[key] = string(*(raw.(*[]byte)))
-		}
+// 读取当前行, NULL 值以 nil 表示
+func scanNullableRow(rows *sql.Rows, fields []string) (map[string]*string, error) {
 
-		parsed = append(parsed, parsedTmp)
+	scanStore := make([]interface{}, 0, len(fields))
+	tempData := make([]sql.NullString, len(fields))
+
+	for i := range fields {
+		scanStore = append(scanStore, &tempData[i])
+	}
+
+	if err := rows.Scan(scanStore...); err != nil {
+		return nil, wrapError(err)
+	}
+
+	var parsed map[string]*string = make(map[string]*string, len(fields))
+
+	for i, field := range fields {
+		if tempData[i].Valid {
+			parsed[field] = &tempData[i].String
+		} else {
+			parsed[field] = nil
+		}
 	}
 
 	return parsed, nil
@@ -103,13 +154,21 @@ func (this *ClientQueryResult) FirstToMap() (map[string]string, error) {
 //	}
 func (this *ClientQueryResult) FirstToStruct(v interface{}) error {
 
-	first, err := this.FirstToMap()
+	maps, err := this.toNullableMap()
 
 	if err != nil {
 		return err
 	}
 
-	return mapToStruct(first, v)
+	if len(maps) < 1 {
+		return &EmptyRowsError{}
+	}
+
+	if err := mapToStruct(maps[0], v); err != nil {
+		return err
+	}
+
+	return runHooks(this.db, v, hookAfterFind)
 
 }
 
@@ -145,9 +204,15 @@ func (this *ClientQueryResult) FirstToStruct(v interface{}) error {
 // string
 //
 // []byte
+//
+// time.Time
+//
+// 以上类型的指针(NULL 时为 nil)
+//
+// 实现了 sql.Scanner 的类型,如 sql.NullString, sql.NullTime
 func (this *ClientQueryResult) ToStruct(containers interface{}) error {
 
-	maps, err := this.ToMap()
+	maps, err := this.toNullableMap()
 
 	if err != nil {
 		return err
@@ -174,13 +239,17 @@ func (this *ClientQueryResult) ToStruct(containers interface{}) error {
 			return err
 		}
 
+		if err := runHooks(this.db, nv.Interface(), hookAfterFind); err != nil {
+			return err
+		}
+
 		v.Set(reflect.Append(v, nv.Elem()))
 	}
 
 	return nil
 }
 
-func mapToStruct(mapV map[string]string, structV interface{}) error {
+func mapToStruct(mapV map[string]*string, structV interface{}) error {
 
 	t := reflect.TypeOf(structV).Elem()
 
@@ -193,7 +262,7 @@ func mapToStruct(mapV map[string]string, structV interface{}) error {
 	return mapToReflect(mapV, t, p)
 }
 
-func mapToReflect(mapV map[string]string, t reflect.Type, p reflect.Value) error {
+func mapToReflect(mapV map[string]*string, t reflect.Type, p reflect.Value) error {
 
 	if p.Kind() != reflect.Struct {
 		return &ReflectError{s: "store value is non-struct."}
@@ -203,189 +272,258 @@ func mapToReflect(mapV map[string]string, t reflect.Type, p reflect.Value) error
 		return &ReflectError{s: "store struct is empty."}
 	}
 
-	for i := 0; i < t.NumField(); i++ {
+	for _, f := range getStructMeta(t).fields {
+
+		tv, ok := mapV[f.column]
+
+		if !ok {
+			continue
+		}
+
+		if err := f.decode(fieldByIndexAlloc(p, f.index), tv); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-		field := t.Field(i)
-		fv := p.FieldByName(field.Name)
+func StructToMap(structV interface{}) (map[string]string, error) {
 
-		if field.Anonymous {
+	row, err := structToValues(structV)
 
-			if fv.Type().Kind() == reflect.Ptr {
-				newVal := reflect.New(fv.Type().Elem())
-				_ = mapToReflect(mapV, newVal.Type().Elem(), newVal.Elem())
-				fv.Set(newVal)
+	if err != nil {
+		return nil, err
+	}
+
+	return valuesToStrMap(row.values), nil
+}
+
+// 一行待写入的数据.
+// columns 决定了生成 SQL 时字段的顺序: struct 按字段声明顺序, map 按 key 排序.
+// 相同的输入总是生成相同的 SQL, 便于语句摘要统计与日志比对
+type rowValues struct {
+	columns []string
+	values  map[string]interface{}
+
+	// 来源为 struct 时的映射信息, 用于处理 db tag 选项以及插入后回填生成的 ID
+	meta *structMeta
+	zero map[string]bool // 值为零的 auto、default、自动时间与软删除字段
+	src  reflect.Value   // 可寻址的来源 struct, 无法回填时为零值
+}
+
+func newRowValues(n int) *rowValues {
+	return &rowValues{columns: make([]string, 0, n), values: make(map[string]interface{}, n)}
+}
+
+func (this *rowValues) Len() int {
+	return len(this.columns)
+}
+
+func (this *rowValues) set(column string, v interface{}) {
+	if _, ok := this.values[column]; !ok {
+		this.columns = append(this.columns, column)
+	}
+	this.values[column] = v
+}
+
+// 保留来源信息的空 rowValues
+func (this *rowValues) derive(n int) *rowValues {
+	ret := newRowValues(n)
+	ret.meta = this.meta
+	ret.zero = this.zero
+	ret.src = this.src
+	return ret
+}
+
+// 自增主键为零值, 插入时由数据库生成
+func (this *rowValues) autoZero() bool {
+	return this.meta != nil && this.meta.auto != nil && this.zero[this.meta.auto.column]
+}
+
+// 插入时写入的字段: 零值的 auto 与 default 字段不写入, default=xxx 字段写入 xxx,
+// 零值的 autoCreateTime 与 autoUpdateTime 字段写入当前时间, 零值的软删除时间字段写入 NULL
+func (this *rowValues) forInsert(now time.Time) (*rowValues, error) {
+
+	if len(this.zero) < 1 {
+		return this, nil
+	}
+
+	ret := this.derive(len(this.columns))
+
+	for _, f := range this.meta.fields {
+
+		v, ok := this.values[f.column]
+
+		switch {
+		case this.zero[f.column] && (f.createTime || f.updateTime):
+			if err := this.stamp(ret, f, now); err != nil {
+				return nil, err
 			}
+		case !ok:
+		case !this.zero[f.column]:
+			ret.set(f.column, v)
+		case f.hasDefault && f.defaultValue != nil:
+			ret.set(f.column, *f.defaultValue)
+		case f.softDelete == SoftDeleteTime && !f.hasDefault:
+			ret.set(f.column, nil)
+		case !f.auto && !f.hasDefault:
+			ret.set(f.column, v)
 		}
+	}
 
-		tag := field.Tag.Get("db")
+	return ret, nil
+}
 
-		if len(tag) < 1 || tag == "-" {
-			continue
+// 更新时写入的字段: 不包含 pk、auto、autoCreateTime 与软删除字段, autoUpdateTime 字段写入当前时间
+func (this *rowValues) forUpdate(now time.Time) (*rowValues, error) {
+
+	if this.meta == nil {
+		return this, nil
+	}
+
+	ret := this.derive(len(this.columns))
+
+	for _, k := range this.columns {
+		if f := this.meta.columns[k]; f == nil || !f.skipUpdate() {
+			ret.set(k, this.values[k])
 		}
+	}
 
-		if tv, ok := mapV[tag]; ok {
+	return ret.touch(now)
+}
 
-			var s StrTo
-			s.Set(tv)
+// autoUpdateTime 字段写入当前时间, 没有的字段会被加上
+func (this *rowValues) touch(now time.Time) (*rowValues, error) {
 
-			if !fv.IsValid() || !fv.CanSet() {
-				return &ReflectError{s: "filed:" + field.Name + " value error"}
+	if this.meta == nil || !this.meta.updateTime {
+		return this, nil
+	}
+
+	ret := this.derive(len(this.columns) + 1)
+
+	for _, k := range this.columns {
+		ret.set(k, this.values[k])
+	}
+
+	for _, f := range this.meta.fields {
+		if f.updateTime {
+			if err := this.stamp(ret, f, now); err != nil {
+				return nil, err
 			}
+		}
+	}
 
-			var de error = nil
+	return ret, nil
+}
 
-			switch filev := field.Type.Kind(); filev {
+// 去掉 fields 中的 autoCreateTime 字段
+func (this *rowValues) withoutCreateTime(fields []string) []string {
 
-			case reflect.Uint8:
-				{
-					if dv, de := s.Uint8(); de == nil {
-						fv.SetUint(uint64(dv))
-					}
-					break
-				}
-			case reflect.Uint16:
-				{
-					if dv, de := s.Uint16(); de == nil {
-						fv.SetUint(uint64(dv))
-					}
-					break
-				}
-			case reflect.Uint32:
-				{
-					if dv, de := s.Uint32(); de == nil {
-						fv.SetUint(uint64(dv))
-					}
-					break
-				}
-			case reflect.Uint64:
-				{
-					if dv, de := s.Uint64(); de == nil {
-						fv.SetUint(uint64(dv))
-					}
-					break
-				}
-			case reflect.Uint:
-				{
-					if dv, de := s.Uint(); de == nil {
-						fv.SetUint(uint64(dv))
-					}
-					break
-				}
+	if this.meta == nil {
+		return fields
+	}
 
-			case reflect.Int8:
-				{
-					if dv, de := s.Int8(); de == nil {
-						fv.SetInt(int64(dv))
-					}
-					break
-				}
-			case reflect.Int16:
-				{
-					if dv, de := s.Int16(); de == nil {
-						fv.SetInt(int64(dv))
-					}
-					break
-				}
-			case reflect.Int32:
-				{
-					if dv, de := s.Int32(); de == nil {
-						fv.SetInt(int64(dv))
-					}
-					break
-				}
-			case reflect.Int64:
-				{
-					if dv, de := s.Int64(); de == nil {
-						fv.SetInt(int64(dv))
-					}
-					break
-				}
-			case reflect.Int:
-				{
-					if dv, de := s.Int(); de == nil {
-						fv.SetInt(int64(dv))
-					}
-					break
-				}
-			case reflect.Float32:
-				{
-					if dv, de := s.Float32(); de == nil {
-						fv.SetFloat(float64(dv))
-					}
-					break
-				}
-			case reflect.Float64:
-				{
-					if dv, de := s.Float64(); de == nil {
-						fv.SetFloat(float64(dv))
-					}
-					break
-				}
+	ret := make([]string, 0, len(fields))
 
-			case reflect.String:
-				{
-					fv.SetString(s.String())
-					break
-				}
-			case reflect.Bool:
-				{
-					if dv, de := s.Bool(); de == nil {
-						fv.SetBool(dv)
-					}
-				}
+	for _, k := range fields {
+		if f := this.meta.columns[k]; f == nil || !f.createTime {
+			ret = append(ret, k)
+		}
+	}
 
-			default:
-				{
-
-					m := fv.MethodByName("UnmarshalDB")
-
-					if m.IsValid() {
-						if len(s.String()) > 0 {
-							var setEle reflect.Value
-							if fv.Type().Kind() == reflect.Ptr {
-								setEle = reflect.New(fv.Type().Elem())
-							} else {
-								setEle = reflect.New(fv.Type())
-							}
-
-							nm := setEle.MethodByName("UnmarshalDB")
-
-							vals := nm.Call([]reflect.Value{
-								reflect.ValueOf([]byte(s.String())),
-							})
-
-							if len(vals) > 0 {
-								errVal := vals[0]
-								if !errVal.IsNil() {
-									de = &ReflectError{"marshal error:" + errVal.Interface().(error).Error()}
-								} else {
-									fv.Set(setEle)
-								}
-							}
-						}
-
-					} else {
-						de = &ReflectError{s: "unsuported type:" + filev.String()}
-					}
-				}
+	return ret
+}
+
+// 将 autoCreateTime/autoUpdateTime 字段的值设置为 now, 同时写回来源 struct
+func (this *rowValues) stamp(ret *rowValues, f *fieldMeta, now time.Time) error {
+
+	tv, ok := timeValueOf(f.typ, now)
+
+	if !ok {
+		return &ReflectError{s: "unsupported auto time field type:" + f.typ.String()}
+	}
+
+	if this.src.IsValid() {
+		fieldByIndexAlloc(this.src, f.index).Set(tv)
+	}
+
+	dv, err := f.encode(tv)
+
+	if err != nil {
+		return err
+	}
+
+	ret.set(f.column, dv)
+	return nil
+}
+
+// 将数据库生成的自增 ID 写回来源 struct
+func (this *rowValues) setAutoId(id int64) error {
+
+	if this.meta == nil || this.meta.auto == nil || !this.src.IsValid() {
+		return nil
+	}
+
+	raw := strconv.FormatInt(id, 10)
+	auto := this.meta.auto
+
+	return auto.decode(fieldByIndexAlloc(this.src, auto.index), &raw)
+}
+
+// 多行数据的全部字段, 按第一次出现的顺序排列
+func unionColumns(list []*rowValues) []string {
+
+	columns := list[0].columns
+	var seen map[string]bool
+
+	for _, row := range list[1:] {
+		for _, k := range row.columns {
+
+			if _, ok := list[0].values[k]; ok {
+				continue
+			}
+
+			if seen == nil {
+				columns = append([]string{}, columns...)
+				seen = make(map[string]bool)
 			}
 
-			if de != nil {
-				return de
+			if !seen[k] {
+				seen[k] = true
+				columns = append(columns, k)
 			}
 		}
 	}
 
-	return nil
+	return columns
 }
 
-func StructToMap(structV interface{}) (map[string]string, error) {
+// 按 fields 的顺序挑选部分字段, 不存在的字段会被忽略
+func (this *rowValues) pick(fields []string) *rowValues {
+
+	ret := this.derive(len(fields))
+
+	for _, f := range fields {
+		if v, ok := this.values[f]; ok {
+			ret.set(f, v)
+		}
+	}
+
+	return ret
+}
+
+// 与 StructToMap 相同, 但保留可直接交给驱动的值.
+// NULL 以 nil 表示, 二进制数据保持 []byte
+func structToValues(structV interface{}) (*rowValues, error) {
 
 	t := reflect.TypeOf(structV)
 
-	if t.Kind() == reflect.Map {
+	if t == nil {
+		return nil, &ReflectError{s: "store struct is nil"}
+	}
 
-		retMap := make(map[string]string, 0)
+	if t.Kind() == reflect.Map {
 
 		parsedStructV, ok := structV.(map[string]interface{})
 
@@ -393,11 +531,25 @@ func StructToMap(structV interface{}) (map[string]string, error) {
 			return nil, &ReflectError{s: "unsupported this map type:" + t.String() + ", supported map[string]interface{} only."}
 		}
 
-		for k, v := range parsedStructV {
-			retMap[k] = ToStr(v)
+		keys := make([]string, 0, len(parsedStructV))
+
+		for k := range parsedStructV {
+			keys = append(keys, k)
 		}
 
-		return retMap, nil
+		sort.Strings(keys)
+
+		ret := newRowValues(len(keys))
+
+		for _, k := range keys {
+			dv, err := interfaceToDBValue(parsedStructV[k])
+			if err != nil {
+				return nil, err
+			}
+			ret.set(k, dv)
+		}
+
+		return ret, nil
 	}
 
 	p := reflect.ValueOf(structV)
@@ -412,10 +564,10 @@ func StructToMap(structV interface{}) (map[string]string, error) {
 		p = p.Elem()
 	}
 
-	return reflectToMap(t, p)
+	return reflectToValues(t, p)
 }
 
-func reflectToMap(t reflect.Type, p reflect.Value) (map[string]string, error) {
+func reflectToValues(t reflect.Type, p reflect.Value) (*rowValues, error) {
 
 	if p.Kind() != reflect.Struct {
 		return nil, &ReflectError{s: "struct is non-struct"}
@@ -425,73 +577,44 @@ func reflectToMap(t reflect.Type, p reflect.Value) (map[string]string, error) {
 		return nil, &ReflectError{s: "store struct is empty"}
 	}
 
-	ret := make(map[string]string, 0)
-
-	for i := 0; i < t.NumField(); i++ {
+	meta := getStructMeta(t)
 
-		field := t.Field(i)
-		fv := p.FieldByName(field.Name)
-		if field.Anonymous {
-			var inner map[string]string
-			if fv.Type().Kind() == reflect.Ptr {
-				temp, err := reflectToMap(fv.Type().Elem(), fv.Elem())
-				if err != nil {
-					return nil, err
-				}
-				inner = temp
-			}
-			if fv.Type().Kind() == reflect.Struct {
-				temp, err := reflectToMap(fv.Type(), fv)
-				if err != nil {
-					return nil, err
-				}
-				inner = temp
-			}
-
-			for k, v := range inner {
-				ret[k] = v
-			}
-		}
+	ret := newRowValues(len(meta.fields))
+	ret.meta = meta
 
+	if p.CanSet() {
+		ret.src = p
 	}
 
-	for i := 0; i < t.NumField(); i++ {
+	for _, f := range meta.fields {
 
-		field := t.Field(i)
-		fv := p.FieldByName(field.Name)
-		if field.Anonymous {
-			continue
-		}
-		tag := field.Tag.Get("db")
-		if len(tag) < 1 || tag == "-" {
+		if f.readonly {
 			continue
 		}
 
-		var storStr string = ""
+		fv, ok := fieldByIndex(p, f.index)
 
-		if fv.Type().Kind() == reflect.Ptr && fv.IsNil() {
-			//skip nil pointer
-		} else {
+		if !ok || ((f.omitempty || f.trackZero()) && fv.IsZero()) {
 
-			m := fv.MethodByName("MarshalDB")
-			if m.IsValid() {
-				vals := m.Call([]reflect.Value{})
-				if len(vals) > 0 {
-					dataVal := vals[0]
-					errVal := vals[1]
-					if errVal.IsNil() && dataVal.CanInterface() {
-						data := dataVal.Interface().([]byte)
-						storStr = string(data)
-					} else {
-						return nil, &ReflectError{s: "MarshalDB error:" + errVal.Interface().(error).Error()}
-					}
+			if f.trackZero() {
+				if ret.zero == nil {
+					ret.zero = make(map[string]bool)
 				}
-			} else {
-				storStr = ToStr(fv.Interface())
+				ret.zero[f.column] = true
+			}
+
+			if !ok || f.omitempty {
+				continue
 			}
 		}
 
-		ret[tag] = storStr
+		dv, err := f.encode(fv)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ret.set(f.column, dv)
 	}
 
 	return ret, nil
@@ -500,7 +623,24 @@ func reflectToMap(t reflect.Type, p reflect.Value) (map[string]string, error) {
 
 func ListStructToMap(vs interface{}) ([]map[string]string, error) {
 
-	ret := make([]map[string]string, 0)
+	list, err := listStructToValues(vs)
+
+	ret := make([]map[string]string, 0, len(list))
+
+	if err != nil {
+		return ret, err
+	}
+
+	for _, row := range list {
+		ret = append(ret, valuesToStrMap(row.values))
+	}
+
+	return ret, nil
+}
+
+func listStructToValues(vs interface{}) ([]*rowValues, error) {
+
+	ret := make([]*rowValues, 0)
 
 	//t := reflect.TypeOf(vs)
 	p := reflect.ValueOf(vs)
@@ -525,12 +665,35 @@ func ListStructToMap(vs interface{}) ([]map[string]string, error) {
 	for i := 0; i < len; i++ {
 		v := p.Index(i)
 
-		rv, re := reflectToMap(v.Type(), v)
+		// []*T 的元素取指向的 struct, 回填自增主键时写回原对象
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return ret, &ReflectError{s: "struct pointer is nil"}
+			}
+			v = v.Elem()
+		}
+
+		rv, re := reflectToValues(v.Type(), v)
 		if re != nil {
-			return ret, &ReflectError{s: "error:" + re.Error()}
+			return ret, &ReflectError{s: "error:" + re.Error(), err: re}
 		}
 		ret = append(ret, rv)
 	}
 
 	return ret, nil
 }
+
+func valuesToStrMap(vmap map[string]interface{}) map[string]string {
+
+	ret := make(map[string]string, len(vmap))
+
+	for k, v := range vmap {
+		if v == nil {
+			ret[k] = ""
+		} else {
+			ret[k] = ToStr(v)
+		}
+	}
+
+	return ret
+}
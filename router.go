@@ -0,0 +1,202 @@
+package litedb
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// 分表路由, 将逻辑表名与路由值映射为物理表名.
+// 设置到 Sql 上之后, Insert/Update/Delete/BatchInsert 等语法糖传入的 table 均视为逻辑表名
+type TableRouter interface {
+	// 逻辑表的路由字段, 返回空字符串表示该表不分表
+	RouteColumn(table string) string
+
+	// 根据逻辑表名与路由值返回物理表名
+	Route(table string, value interface{}) (string, error)
+}
+
+type splitTable struct {
+	column string
+	n      int
+	fn     ShardFunc
+	format string
+}
+
+// 按 ShardFunc 分表, 物理表名为 逻辑表名_序号, 序号按分表数量补零, 如 order_00 ... order_99
+type SplitTableRouter struct {
+	tables map[string]*splitTable
+}
+
+func NewSplitTableRouter() *SplitTableRouter {
+	return &SplitTableRouter{tables: make(map[string]*splitTable)}
+}
+
+// 注册分表: table 按 column 的值拆分为 n 张表, fn 为 nil 时按 n 取模.
+// n 必须大于 0
+func (this *SplitTableRouter) Add(table string, column string, n int, fn ShardFunc) error {
+
+	if n <= 0 {
+		return &SQLError{s: "SplitTableRouter.Add needs a positive number of tables for `" + table + "`, got " + strconv.Itoa(n)}
+	}
+
+	if fn == nil {
+		fn, _ = ModuloShard(n)
+	}
+
+	width := len(strconv.Itoa(n - 1))
+
+	this.tables[table] = &splitTable{
+		column: column,
+		n:      n,
+		fn:     fn,
+		format: "%s_%0" + strconv.Itoa(width) + "d",
+	}
+
+	return nil
+}
+
+func (this *SplitTableRouter) RouteColumn(table string) string {
+
+	if t, ok := this.tables[table]; ok {
+		return t.column
+	}
+
+	return ""
+}
+
+func (this *SplitTableRouter) Route(table string, value interface{}) (string, error) {
+
+	t, ok := this.tables[table]
+
+	if !ok {
+		return table, nil
+	}
+
+	i := t.fn(value) % t.n
+
+	if i < 0 {
+		i += t.n
+	}
+
+	return fmt.Sprintf(t.format, table, i), nil
+}
+
+// 设置分表路由, 由该 Sql 开启的事务会继承分表路由
+func (this *Sql) SetTableRouter(router TableRouter) {
+	this.router = router
+}
+
+// Route 返回使用指定路由值的 Sql, 语法糖不再从数据中读取路由字段.
+// Delete 等没有数据的操作必须通过 Route 指定路由值:
+//
+//	client.Route(order.UserId).Delete("order", "id = ?", order.Id)
+func (this *Sql) Route(value interface{}) *Sql {
+
+	routed := *this
+	routed.routed = true
+	routed.routeValue = value
+
+	return &routed
+}
+
+// 逻辑表对应的物理表名, 用于自行拼写 SQL 的场景
+func (this *Sql) Table(table string, value interface{}) (string, error) {
+
+	if this.router == nil || this.router.RouteColumn(table) == "" {
+		return table, nil
+	}
+
+	return this.router.Route(table, value)
+}
+
+// 语法糖使用的物理表名, 未通过 Route 指定路由值时从 row 中读取路由字段
+func (this *Sql) routeTable(table string, row *rowValues) (string, error) {
+
+	if this.router == nil {
+		return table, nil
+	}
+
+	column := this.router.RouteColumn(table)
+
+	if column == "" {
+		return table, nil
+	}
+
+	if this.routed {
+		return this.router.Route(table, this.routeValue)
+	}
+
+	if row != nil {
+		if value, ok := row.values[column]; ok && value != nil {
+			return this.router.Route(table, value)
+		}
+	}
+
+	return "", &SQLError{s: "missing route value `" + column + "` for table `" + table + "`"}
+}
+
+// 将批量数据按物理表分组, 保持每张表第一次出现的顺序
+func (this *Sql) routeRows(table string, list []*rowValues) ([]string, [][]*rowValues, error) {
+
+	tables := make([]string, 0, 1)
+	groups := make([][]*rowValues, 0, 1)
+	index := make(map[string]int)
+
+	for _, row := range list {
+
+		name, err := this.routeTable(table, row)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		i, ok := index[name]
+
+		if !ok {
+			i = len(tables)
+			index[name] = i
+			tables = append(tables, name)
+			groups = append(groups, nil)
+		}
+
+		groups[i] = append(groups[i], row)
+	}
+
+	return tables, groups, nil
+}
+
+// 多条语句的执行结果.
+// LastInsertId 为第一条语句的结果, RowsAffected 为全部语句之和
+type multiResult []sql.Result
+
+func (this multiResult) LastInsertId() (int64, error) {
+
+	if len(this) < 1 || this[0] == nil {
+		return 0, nil
+	}
+
+	return this[0].LastInsertId()
+}
+
+func (this multiResult) RowsAffected() (int64, error) {
+
+	var total int64
+
+	for _, r := range this {
+
+		if r == nil {
+			continue
+		}
+
+		n, err := r.RowsAffected()
+
+		if err != nil {
+			return total, err
+		}
+
+		total += n
+	}
+
+	return total, nil
+}
@@ -0,0 +1,369 @@
+package litedb
+
+import (
+	"database/sql"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// 直接扫描的方式将结果集映射到 struct.
+// 与 ToStruct 不同, 这里根据 Rows.ColumnTypes() 与 db tag 为每个字段构建扫描目标,
+// 驱动返回的值直接写入对应类型的字段, 不再经过 string 的中间转换.
+// BIGINT UNSIGNED 映射到 uint64 字段, DECIMAL 与二进制数据映射到 string 或 []byte 字段时不会丢失精度,
+// DECIMAL 映射到 float32/float64 字段时仍然会丢失精度.
+// 与 ToStruct 不同的是, 字段类型转换失败时总是返回错误, 不受 StrictDecode 的影响.
+// RowIterator.Scan 与 Each 同样使用这种方式.
+
+// 结果集列与 struct 字段的对应关系, 在一次查询中只计算一次
+type scanPlan struct {
+	typ     reflect.Type
+	columns []*sql.ColumnType
+	fields  []*fieldMeta // 每一列对应的字段, 未匹配的列为 nil
+
+	// 索引路径不经过匿名指针时, 各行可以复用同一组扫描目标
+	reusable bool
+}
+
+func newScanPlan(t reflect.Type, columns []*sql.ColumnType) (*scanPlan, error) {
+
+	if t.Kind() != reflect.Struct {
+		return nil, &ReflectError{s: "store value is non-struct."}
+	}
+
+	meta := getStructMeta(t)
+
+	plan := &scanPlan{typ: t, columns: columns, fields: make([]*fieldMeta, len(columns))}
+
+	plan.reusable = true
+
+	for i, col := range columns {
+		f := meta.columns[col.Name()]
+		plan.fields[i] = f
+
+		if f != nil && f.viaPtr {
+			plan.reusable = false
+		}
+	}
+
+	return plan, nil
+}
+
+// 为 struct 值 p 构建本行的扫描目标
+func (this *scanPlan) dests(p reflect.Value) []interface{} {
+
+	dests := make([]interface{}, len(this.columns))
+
+	for i, f := range this.fields {
+
+		if f == nil {
+			dests[i] = new(sql.RawBytes)
+			continue
+		}
+
+		dests[i] = fieldDest(fieldByIndexAlloc(p, f.index), f)
+	}
+
+	return dests
+}
+
+// 根据字段类型选择扫描目标
+func fieldDest(fv reflect.Value, f *fieldMeta) interface{} {
+
+	ft := f.typ
+
+	if f.json {
+		return &rawDest{fv: fv, decode: f.decode}
+	}
+
+	if ft.Kind() == reflect.Interface {
+		return fv.Addr().Interface()
+	}
+
+	if ft == timeType || ft == nullTimeType || hasMethod(ft, "UnmarshalDB") {
+		return &rawDest{fv: fv, decode: f.decode}
+	}
+
+	if ft.Kind() == reflect.Ptr {
+		if ft.Elem() == timeType || ft.Elem() == nullTimeType {
+			return &rawDest{fv: fv, decode: f.decode}
+		}
+		return fv.Addr().Interface()
+	}
+
+	if reflect.PtrTo(ft).Implements(scannerType) {
+		return fv.Addr().Interface()
+	}
+
+	return &valueDest{fv: fv}
+}
+
+// 基础类型的扫描目标, NULL 转换为零值
+type valueDest struct {
+	fv reflect.Value
+}
+
+func (this *valueDest) Scan(src interface{}) error {
+
+	fv := this.fv
+
+	if src == nil {
+		fv.Set(reflect.Zero(fv.Type()))
+		return nil
+	}
+
+	var err error
+
+	switch fv.Kind() {
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		{
+			var n int64
+			switch s := src.(type) {
+			case int64:
+				n = s
+			case bool:
+				if s {
+					n = 1
+				}
+			default:
+				n, err = strconv.ParseInt(srcString(src), 10, 64)
+			}
+			if err == nil && fv.OverflowInt(n) {
+				err = strconv.ErrRange
+			}
+			if err == nil {
+				fv.SetInt(n)
+			}
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		{
+			var n uint64
+			switch s := src.(type) {
+			case int64:
+				if s < 0 {
+					err = strconv.ErrRange
+				}
+				n = uint64(s)
+			case uint64:
+				n = s
+			default:
+				n, err = strconv.ParseUint(srcString(src), 10, 64)
+			}
+			if err == nil && fv.OverflowUint(n) {
+				err = strconv.ErrRange
+			}
+			if err == nil {
+				fv.SetUint(n)
+			}
+		}
+
+	case reflect.Float32, reflect.Float64:
+		{
+			var f float64
+			switch s := src.(type) {
+			case float64:
+				f = s
+			case float32:
+				f = float64(s)
+			case int64:
+				f = float64(s)
+			default:
+				f, err = strconv.ParseFloat(srcString(src), fv.Type().Bits())
+			}
+			if err == nil {
+				fv.SetFloat(f)
+			}
+		}
+
+	case reflect.Bool:
+		{
+			switch s := src.(type) {
+			case bool:
+				fv.SetBool(s)
+			case int64:
+				fv.SetBool(s != 0)
+			default:
+				var b bool
+				if b, err = strconv.ParseBool(srcString(src)); err == nil {
+					fv.SetBool(b)
+				}
+			}
+		}
+
+	case reflect.String:
+		{
+			fv.SetString(srcString(src))
+		}
+
+	case reflect.Slice:
+		{
+			if fv.Type().Elem().Kind() != reflect.Uint8 {
+				return &ReflectError{s: "unsuported type:" + fv.Type().String()}
+			}
+			// 驱动返回的 []byte 在下一次 Next 后失效, 需要复制
+			if b, ok := src.([]byte); ok {
+				fv.SetBytes(append([]byte(nil), b...))
+			} else {
+				fv.SetBytes([]byte(srcString(src)))
+			}
+		}
+
+	default:
+		return &ReflectError{s: "unsuported type:" + fv.Type().String()}
+	}
+
+	if err != nil {
+		return &ReflectError{s: "convert " + srcString(src) + " to " + fv.Type().String() + " error:" + err.Error(), err: err}
+	}
+
+	return nil
+}
+
+// time.Time 与 UnmarshalDB 等需要文本形式的扫描目标, 转换规则与 ToStruct 相同
+type rawDest struct {
+	fv     reflect.Value
+	decode fieldDecoder
+}
+
+func (this *rawDest) Scan(src interface{}) error {
+
+	if src == nil {
+		return this.decode(this.fv, nil)
+	}
+
+	if t, ok := src.(time.Time); ok {
+		switch this.fv.Type() {
+		case timeType:
+			this.fv.Set(reflect.ValueOf(t))
+			return nil
+		case reflect.PtrTo(timeType):
+			this.fv.Set(reflect.ValueOf(&t))
+			return nil
+		}
+	}
+
+	s := srcString(src)
+	return this.decode(this.fv, &s)
+}
+
+func srcString(src interface{}) string {
+	switch s := src.(type) {
+	case []byte:
+		return string(s)
+	case string:
+		return s
+	default:
+		return ToStr(s)
+	}
+}
+
+// ScanToStruct 使用直接扫描的方式将结果集转换成一个 struct 数组.
+// 用法与 ToStruct 相同:
+//
+//	var containers []Person
+//	ScanToStruct(&containers)
+func (this *ClientQueryResult) ScanToStruct(containers interface{}) error {
+
+	if this.Err != nil {
+		return wrapError(this.Err)
+	}
+
+	defer this.Rows.Close()
+
+	val := reflect.ValueOf(containers)
+	typ := reflect.TypeOf(containers)
+
+	if typ == nil || typ.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Slice {
+		return &ReflectError{s: "unsuported reflect type:" + reflect.ValueOf(containers).Kind().String()}
+	}
+
+	etyp := typ.Elem().Elem()
+
+	columns, err := this.Rows.ColumnTypes()
+
+	if err != nil {
+		return wrapError(err)
+	}
+
+	plan, err := newScanPlan(etyp, columns)
+
+	if err != nil {
+		return err
+	}
+
+	v := val.Elem()
+
+	// 每一行先扫描到 nv 中再复制进结果集
+	nv := reflect.New(etyp).Elem()
+	dests := plan.dests(nv)
+
+	for this.Rows.Next() {
+
+		if plan.reusable {
+			nv.Set(reflect.Zero(etyp))
+		} else {
+			nv = reflect.New(etyp).Elem()
+			dests = plan.dests(nv)
+		}
+
+		if err := this.Rows.Scan(dests...); err != nil {
+			return wrapError(err)
+		}
+
+		if err := runHooks(this.db, nv.Addr().Interface(), hookAfterFind); err != nil {
+			return err
+		}
+
+		v.Set(reflect.Append(v, nv))
+	}
+
+	if err := this.Rows.Err(); err != nil {
+		return wrapError(err)
+	}
+
+	return nil
+}
+
+// FirstScanToStruct 使用直接扫描的方式将首行解析成一个 struct, 需要传递一个 struct 的指针.
+// 没有结果时返回 EmptyRowsError
+func (this *ClientQueryResult) FirstScanToStruct(v interface{}) error {
+
+	if this.Err != nil {
+		return wrapError(this.Err)
+	}
+
+	defer this.Rows.Close()
+
+	p := reflect.ValueOf(v)
+
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return &ReflectError{s: "store struct is nil-pointer"}
+	}
+
+	columns, err := this.Rows.ColumnTypes()
+
+	if err != nil {
+		return wrapError(err)
+	}
+
+	plan, err := newScanPlan(p.Elem().Type(), columns)
+
+	if err != nil {
+		return err
+	}
+
+	if !this.Rows.Next() {
+		if err := this.Rows.Err(); err != nil {
+			return wrapError(err)
+		}
+		return &EmptyRowsError{}
+	}
+
+	if err := this.Rows.Scan(plan.dests(p.Elem())...); err != nil {
+		return wrapError(err)
+	}
+
+	return runHooks(this.db, v, hookAfterFind)
+}
@@ -0,0 +1,291 @@
+package litedb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 仅用于测试的内存驱动, 以文本协议的形式([]byte)返回固定的结果集
+type benchDriver struct{}
+
+type benchConn struct{}
+
+type benchStmt struct{}
+
+type benchRows struct {
+	n, i int
+	fail bool // 读完 n 行后返回错误
+}
+
+var benchColumns = []string{"id", "name", "balance", "score", "flag", "created_at", "remark"}
+
+func (benchDriver) Open(name string) (driver.Conn, error) { return benchConn{}, nil }
+
+func (benchConn) Prepare(query string) (driver.Stmt, error) { return benchStmt{}, nil }
+func (benchConn) Close() error                              { return nil }
+func (benchConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+
+func (benchStmt) Close() error                                    { return nil }
+func (benchStmt) NumInput() int                                   { return -1 }
+func (benchStmt) Exec(args []driver.Value) (driver.Result, error) { return nil, driver.ErrSkip }
+func (benchStmt) Query(args []driver.Value) (driver.Rows, error) {
+	arg := args[0].(string)
+	fail := strings.HasSuffix(arg, "!")
+	n, _ := strconv.Atoi(strings.TrimSuffix(arg, "!"))
+	return &benchRows{n: n, fail: fail}, nil
+}
+
+func (this *benchRows) Columns() []string { return benchColumns }
+func (this *benchRows) Close() error      { return nil }
+func (this *benchRows) Next(dest []driver.Value) error {
+
+	if this.i >= this.n {
+		if this.fail {
+			return errBenchRows
+		}
+		return io.EOF
+	}
+
+	this.i++
+
+	dest[0] = []byte(strconv.Itoa(this.i))
+	dest[1] = []byte("name-" + strconv.Itoa(this.i))
+	dest[2] = []byte("18446744073709551615")
+	dest[3] = []byte("99.5")
+	dest[4] = []byte("1")
+	dest[5] = []byte("2024-01-02 03:04:05.123456")
+
+	if this.i%2 == 0 {
+		dest[6] = nil
+	} else {
+		dest[6] = []byte("remark")
+	}
+
+	return nil
+}
+
+var benchDB *sql.DB
+
+var errBenchRows = errors.New("bench rows broken")
+
+func init() {
+	sql.Register("litedb_bench", benchDriver{})
+	benchDB, _ = sql.Open("litedb_bench", "")
+}
+
+type benchRow struct {
+	Id        int64     `db:"id"`
+	Name      string    `db:"name"`
+	Balance   uint64    `db:"balance"`
+	Score     float64   `db:"score"`
+	Flag      bool      `db:"flag"`
+	CreatedAt time.Time `db:"created_at"`
+	Remark    *string   `db:"remark"`
+}
+
+func benchQuery(n int) *ClientQueryResult {
+	return benchQueryArg(strconv.Itoa(n))
+}
+
+// arg 为行数, 以 ! 结尾时读完全部行后返回 errBenchRows
+func benchQueryArg(arg string) *ClientQueryResult {
+
+	rows, err := benchDB.Query("SELECT", arg)
+
+	return &ClientQueryResult{Rows: rows, Err: err}
+}
+
+func TestScanToStructMatchesToStruct(t *testing.T) {
+
+	var expect []benchRow
+	var got []benchRow
+
+	if err := benchQuery(10).ToStruct(&expect); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := benchQuery(10).ScanToStruct(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expect, got) {
+		t.Fatalf("ScanToStruct = %+v, want %+v", got, expect)
+	}
+
+	if got[0].Balance != 18446744073709551615 || got[0].Remark == nil || got[1].Remark != nil {
+		t.Fatalf("unexpected row: %+v %+v", got[0], got[1])
+	}
+}
+
+func TestFirstScanToStruct(t *testing.T) {
+
+	var row benchRow
+
+	if err := benchQuery(3).FirstScanToStruct(&row); err != nil {
+		t.Fatal(err)
+	}
+
+	if row.Id != 1 || row.Name != "name-1" {
+		t.Fatalf("unexpected row: %+v", row)
+	}
+
+	if _, ok := benchQuery(0).FirstScanToStruct(&row).(*EmptyRowsError); !ok {
+		t.Fatal("expect EmptyRowsError")
+	}
+}
+
+func TestRowIterator(t *testing.T) {
+
+	it := benchQuery(3).Iterator()
+	ids := make([]int64, 0, 3)
+
+	for it.Next() {
+
+		var row benchRow
+
+		if err := it.Scan(&row); err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := it.ScanMap()
+
+		if err != nil || m["remark"] != "" && m["remark"] != "remark" {
+			t.Fatal("unexpected map:", m, err)
+		}
+
+		ids = append(ids, row.Id)
+	}
+
+	if it.Err() != nil || fmt.Sprint(ids) != "[1 2 3]" {
+		t.Fatal("unexpected iteration:", ids, it.Err())
+	}
+
+	if it.Next() {
+		t.Fatal("Next after the end should return false")
+	}
+
+	if err := it.Scan(&benchRow{}); err == nil {
+		t.Fatal("Scan after the end should fail")
+	}
+
+	if it.Close() != nil || it.Close() != nil {
+		t.Fatal("Close should be idempotent")
+	}
+}
+
+func TestRowIteratorScanError(t *testing.T) {
+
+	it := benchQuery(2).Iterator()
+	defer it.Close()
+
+	var bad struct {
+		Name int `db:"name"`
+	}
+
+	// 与 ScanToStruct 一样, 类型转换失败时返回错误
+	if !it.Next() || it.Scan(&bad) == nil {
+		t.Fatal("conversion error expected")
+	}
+
+	var row benchRow
+
+	if err := it.Scan(&row); err != nil || row.Name != "name-1" || row.Balance == 0 {
+		t.Fatalf("the same row should be scanned again with another type: %v %+v", err, row)
+	}
+}
+
+func TestRowIteratorError(t *testing.T) {
+
+	it := benchQueryArg("2!").Iterator()
+	n := 0
+
+	for it.Next() {
+		n++
+	}
+
+	if n != 2 || !errors.Is(it.Err(), errBenchRows) {
+		t.Fatal("rows error should be reported by Err:", n, it.Err())
+	}
+
+	if it.Close() != nil || it.Next() {
+		t.Fatal("Close after an error should succeed and stop the iterator")
+	}
+
+	fail := errors.New("query failed")
+	it = (&ClientQueryResult{Err: fail}).Iterator()
+
+	if it.Next() || !errors.Is(it.Err(), fail) || it.Close() != nil {
+		t.Fatal("query error should be reported by Err:", it.Err())
+	}
+}
+
+func TestEach(t *testing.T) {
+
+	stop := errors.New("stop")
+	result := benchQuery(5)
+	calls := 0
+
+	err := Each(result, func(row benchRow) error {
+		calls++
+		if row.Id == 2 {
+			return stop
+		}
+		return nil
+	})
+
+	if err != stop || calls != 2 {
+		t.Fatal("Each should stop at the first error:", err, calls)
+	}
+
+	if _, err := result.Rows.Columns(); err == nil {
+		t.Fatal("rows should be closed after an early stop")
+	}
+
+	calls = 0
+
+	err = Each(benchQueryArg("1!"), func(row benchRow) error {
+		calls++
+		return nil
+	})
+
+	if !errors.Is(err, errBenchRows) || calls != 1 {
+		t.Fatal("rows error should be returned by Each:", err, calls)
+	}
+}
+
+func benchmarkToStruct(b *testing.B, n int) {
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		var rows []benchRow
+		if err := benchQuery(n).ToStruct(&rows); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkScanToStruct(b *testing.B, n int) {
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		var rows []benchRow
+		if err := benchQuery(n).ScanToStruct(&rows); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkToStruct10(b *testing.B)       { benchmarkToStruct(b, 10) }
+func BenchmarkToStruct1000(b *testing.B)     { benchmarkToStruct(b, 1000) }
+func BenchmarkScanToStruct10(b *testing.B)   { benchmarkScanToStruct(b, 10) }
+func BenchmarkScanToStruct1000(b *testing.B) { benchmarkScanToStruct(b, 1000) }
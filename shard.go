@@ -0,0 +1,307 @@
+package litedb
+
+import (
+	"context"
+	"hash/crc32"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// 分片函数, 根据分片键返回分片序号.
+// 返回值超出分片数量时会按分片数量取模
+type ShardFunc func(key interface{}) int
+
+// 对整数分片键取模, 如 user_id % 16.
+// 与 MySQL 的 % 相同, 负数的余数为负数, ShardedClient 与 SplitTableRouter 再将其加上分片数量, 因此 -5 与 5 落在不同的分片.
+// 非整数的分片键先计算 crc32 再取模. n 必须大于 0
+func ModuloShard(n int) (ShardFunc, error) {
+
+	if n <= 0 {
+		return nil, &SQLError{s: "ModuloShard needs a positive number of shards, got " + strconv.Itoa(n)}
+	}
+
+	return func(key interface{}) int {
+		v, u, signed := shardKeyValue(key)
+		if signed {
+			return int(v % int64(n))
+		}
+		return int(u % uint64(n))
+	}, nil
+}
+
+// 按范围分片.
+// bounds 为升序排列的上界(不包含), 分片键小于 bounds[i] 时落在第 i 个分片,
+// 大于等于最后一个上界时落在最后一个分片
+func RangeShard(bounds []int64) ShardFunc {
+	return func(key interface{}) int {
+		k, u, signed := shardKeyValue(key)
+		if !signed {
+			k = int64(u)
+			if u > math.MaxInt64 {
+				k = math.MaxInt64
+			}
+		}
+		i := sort.Search(len(bounds), func(i int) bool { return k < bounds[i] })
+		if i >= len(bounds) {
+			i = len(bounds) - 1
+		}
+		return i
+	}
+}
+
+// 一致性哈希, n 为分片数量(必须大于 0), vnodes 为每个分片的虚拟节点数量(默认 160)
+func ConsistentHashShard(n int, vnodes int) (ShardFunc, error) {
+
+	if n <= 0 {
+		return nil, &SQLError{s: "ConsistentHashShard needs a positive number of shards, got " + strconv.Itoa(n)}
+	}
+
+	if vnodes < 1 {
+		vnodes = 160
+	}
+
+	type point struct {
+		hash  uint32
+		shard int
+	}
+
+	ring := make([]point, 0, n*vnodes)
+
+	for i := 0; i < n; i++ {
+		for v := 0; v < vnodes; v++ {
+			ring = append(ring, point{crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "-" + strconv.Itoa(v))), i})
+		}
+	}
+
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	return func(key interface{}) int {
+		h := crc32.ChecksumIEEE([]byte(ToStr(key)))
+		i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+		if i >= len(ring) {
+			i = 0
+		}
+		return ring[i].shard
+	}, nil
+}
+
+// 查表分片, 分片键按 ToStr 转换后查找 table, 未找到时使用 fallback
+func LookupShard(table map[string]int, fallback ShardFunc) ShardFunc {
+	return func(key interface{}) int {
+		if shard, ok := table[ToStr(key)]; ok {
+			return shard
+		}
+		return fallback(key)
+	}
+}
+
+// 分片键转换为整数: 有符号整数以及可以按有符号整数解析的字符串 signed 为 true, 值为 v;
+// 无符号整数、超出 int64 的数字字符串以及其他分片键的 crc32 值为 u.
+// 数字字符串与对应的整数得到相同的结果, 分表路由从行数据中读到的路由值都是字符串
+func shardKeyValue(key interface{}) (v int64, u uint64, signed bool) {
+
+	switch key.(type) {
+	case int, int8, int16, int32, int64:
+		return ToInt64(key), 0, true
+	case uint, uint8, uint16, uint32, uint64:
+		return 0, reflect.ValueOf(key).Uint(), false
+	}
+
+	if v, err := strconv.ParseInt(ToStr(key), 10, 64); err == nil {
+		return v, 0, true
+	}
+
+	if u, err := strconv.ParseUint(ToStr(key), 10, 64); err == nil {
+		return 0, u, false
+	}
+
+	return 0, uint64(crc32.ChecksumIEEE([]byte(ToStr(key)))), false
+}
+
+// 分库客户端
+// 持有多个 Client, 根据分片键将操作路由到对应的分片:
+//
+//	sharded, err := litedb.NewShardedClient(clients, nil)
+//	sharded.Shard(user.Id).Insert("user", user)
+//
+// 不带分片键的查询可以使用 QueryAll 在全部分片上并发执行并合并结果
+type ShardedClient struct {
+	shards    []*Client
+	shardFunc ShardFunc
+}
+
+// 初始化分库客户端, fn 为 nil 时按分片数量取模. shards 不能为空
+func NewShardedClient(shards []*Client, fn ShardFunc) (*ShardedClient, error) {
+
+	if len(shards) < 1 {
+		return nil, &SQLError{s: "NewShardedClient needs at least one shard"}
+	}
+
+	if fn == nil {
+		fn, _ = ModuloShard(len(shards))
+	}
+
+	return &ShardedClient{shards: shards, shardFunc: fn}, nil
+}
+
+// 分片键对应的分片序号
+func (this *ShardedClient) ShardIndex(key interface{}) int {
+
+	i := this.shardFunc(key) % len(this.shards)
+
+	if i < 0 {
+		i += len(this.shards)
+	}
+
+	return i
+}
+
+// 分片键对应的客户端
+func (this *ShardedClient) Shard(key interface{}) *Client {
+	return this.shards[this.ShardIndex(key)]
+}
+
+// 全部分片
+func (this *ShardedClient) Shards() []*Client {
+	return this.shards
+}
+
+// 在全部分片上并发执行查询
+func (this *ShardedClient) QueryAll(sqlFmt string, sqlValue ...interface{}) *ShardedQueryResult {
+	return this.QueryAllContext(context.Background(), sqlFmt, sqlValue...)
+}
+
+// QueryAllContext 同 QueryAll, 使用 ctx 控制语句的取消与超时
+func (this *ShardedClient) QueryAllContext(ctx context.Context, sqlFmt string, sqlValue ...interface{}) *ShardedQueryResult {
+
+	result := &ShardedQueryResult{Results: make([]*ClientQueryResult, len(this.shards))}
+
+	this.each(func(i int, shard *Client) {
+		result.Results[i] = shard.QueryContext(ctx, sqlFmt, sqlValue...)
+	})
+
+	return result
+}
+
+// ping 全部分片
+func (this *ShardedClient) Ping() error {
+
+	for _, shard := range this.shards {
+		if err := shard.Ping(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 关闭全部分片
+func (this *ShardedClient) Close() error {
+
+	var ret error
+
+	for _, shard := range this.shards {
+		if err := shard.Close(); err != nil && ret == nil {
+			ret = err
+		}
+	}
+
+	return ret
+}
+
+func (this *ShardedClient) each(fn func(i int, shard *Client)) {
+
+	var wg sync.WaitGroup
+
+	for i, shard := range this.shards {
+		wg.Add(1)
+		go func(i int, shard *Client) {
+			defer wg.Done()
+			fn(i, shard)
+		}(i, shard)
+	}
+
+	wg.Wait()
+}
+
+// ShardedClient.QueryAll 的结果, Results 与分片一一对应
+type ShardedQueryResult struct {
+	Results []*ClientQueryResult
+}
+
+// ToMap 并发读取全部分片的结果集, 按分片顺序合并.
+// 任意分片出错时返回第一个错误, 其余分片的结果集也会被关闭
+func (this *ShardedQueryResult) ToMap() ([]map[string]string, error) {
+
+	maps := make([][]map[string]string, len(this.Results))
+	errs := make([]error, len(this.Results))
+
+	this.each(func(i int, result *ClientQueryResult) {
+		maps[i], errs[i] = result.ToMap()
+	})
+
+	ret := make([]map[string]string, 0)
+
+	for i := range maps {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		ret = append(ret, maps[i]...)
+	}
+
+	return ret, nil
+}
+
+// ToStruct 并发将全部分片的结果集转换为 struct 数组, 按分片顺序合并.
+// 用法与 ClientQueryResult.ToStruct 相同
+func (this *ShardedQueryResult) ToStruct(containers interface{}) error {
+
+	val := reflect.ValueOf(containers)
+	typ := reflect.TypeOf(containers)
+
+	if typ == nil || typ.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Slice {
+		for _, result := range this.Results {
+			if result.Err == nil {
+				result.Rows.Close()
+			}
+		}
+		return &ReflectError{s: "unsuported reflect type:" + val.Kind().String()}
+	}
+
+	parts := make([]reflect.Value, len(this.Results))
+	errs := make([]error, len(this.Results))
+
+	this.each(func(i int, result *ClientQueryResult) {
+		parts[i] = reflect.New(typ.Elem())
+		errs[i] = result.ToStruct(parts[i].Interface())
+	})
+
+	v := val.Elem()
+
+	for i := range parts {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		v.Set(reflect.AppendSlice(v, parts[i].Elem()))
+	}
+
+	return nil
+}
+
+func (this *ShardedQueryResult) each(fn func(i int, result *ClientQueryResult)) {
+
+	var wg sync.WaitGroup
+
+	for i, result := range this.Results {
+		wg.Add(1)
+		go func(i int, result *ClientQueryResult) {
+			defer wg.Done()
+			fn(i, result)
+		}(i, result)
+	}
+
+	wg.Wait()
+}
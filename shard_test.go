@@ -0,0 +1,129 @@
+package litedb
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestShardFunc(t *testing.T) {
+
+	modulo, _ := ModuloShard(4)
+
+	if modulo(int64(10)) != 2 || modulo(uint8(7)) != 3 || modulo("9") != 1 || modulo(-5) != -1 {
+		t.Fatal("unexpected modulo shard")
+	}
+
+	ranges := RangeShard([]int64{100, 200, 300})
+
+	if ranges(0) != 0 || ranges(100) != 1 || ranges(299) != 2 || ranges(1000) != 2 {
+		t.Fatal("unexpected range shard")
+	}
+
+	hash, _ := ConsistentHashShard(4, 0)
+
+	for _, key := range []interface{}{1, "user-1", "user-2", int64(42)} {
+		if s := hash(key); s < 0 || s >= 4 || s != hash(key) {
+			t.Fatal("unexpected consistent hash shard", key, s)
+		}
+	}
+
+	lookup := LookupShard(map[string]int{"vip": 3}, modulo)
+
+	if lookup("vip") != 3 || lookup(6) != 2 {
+		t.Fatal("unexpected lookup shard")
+	}
+}
+
+func TestShardKeyString(t *testing.T) {
+
+	modulo, _ := ModuloShard(7)
+
+	// 分表路由从行数据中读到的是字符串, 必须与整数落在同一个分片
+	for _, key := range []int64{-5, -1, 0, 12, -9223372036854775807} {
+		if modulo(key) != modulo(ToStr(key)) {
+			t.Fatal("string key should be sharded like the integer", key)
+		}
+	}
+
+	if modulo("18446744073709551615") != int(uint64(18446744073709551615)%7) {
+		t.Fatal("unexpected shard for large unsigned string")
+	}
+}
+
+func TestShardNegativeKey(t *testing.T) {
+
+	shards := []*Client{newTxClient(t), newTxClient(t), newTxClient(t), newTxClient(t)}
+	sharded, err := NewShardedClient(shards, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 与 MySQL 的 -5 % 4 = -1 一致, 再加上分片数量
+	if sharded.ShardIndex(-5) != 3 || sharded.ShardIndex("-5") != 3 || sharded.ShardIndex(5) != 1 {
+		t.Fatal("negative keys should not share the shard of their absolute value")
+	}
+
+	router := NewSplitTableRouter()
+	router.Add("order", "user_id", 4, nil)
+
+	if table, _ := router.Route("order", "-5"); table != "order_3" {
+		t.Fatal("unexpected table for negative key", table)
+	}
+
+	ranges := RangeShard([]int64{0, 100})
+
+	if ranges(-5) != 0 || ranges("-5") != 0 || ranges(5) != 1 || ranges(uint64(math.MaxUint64)) != 1 {
+		t.Fatal("negative keys should stay in the negative range")
+	}
+}
+
+func TestShardInvalidCount(t *testing.T) {
+
+	if _, err := ModuloShard(0); err == nil || !strings.Contains(err.Error(), "ModuloShard") {
+		t.Fatal("ModuloShard should reject 0 shards, got", err)
+	}
+
+	if _, err := ConsistentHashShard(-1, 0); err == nil || !strings.Contains(err.Error(), "ConsistentHashShard") {
+		t.Fatal("ConsistentHashShard should reject -1 shards, got", err)
+	}
+
+	if _, err := NewShardedClient(nil, nil); err == nil || !strings.Contains(err.Error(), "NewShardedClient") {
+		t.Fatal("NewShardedClient should reject empty shards, got", err)
+	}
+}
+
+func TestShardedClient(t *testing.T) {
+
+	shards := []*Client{newTxClient(t), newTxClient(t), newTxClient(t)}
+	sharded, err := NewShardedClient(shards, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sharded.Shard(4) != shards[1] || sharded.ShardIndex(-1) != 2 {
+		t.Fatal("unexpected shard")
+	}
+
+	for _, shard := range shards {
+		shard.db = benchDB
+	}
+
+	var rows []benchRow
+
+	if err := sharded.QueryAll("SELECT", "2").ToStruct(&rows); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 6 || rows[0].Id != 1 || rows[1].Id != 2 || rows[2].Id != 1 {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+
+	maps, err := sharded.QueryAll("SELECT", "3").ToMap()
+
+	if err != nil || len(maps) != 9 || maps[8]["name"] != "name-3" {
+		t.Fatal("unexpected maps", maps, err)
+	}
+}
@@ -0,0 +1,295 @@
+package litedb
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// 软删除的标记方式
+type SoftDeleteKind int
+
+const (
+	// 时间字段, 如 deleted_at DATETIME NULL: NULL 表示未删除, 删除时写入 Clock() 的时间
+	SoftDeleteTime SoftDeleteKind = iota + 1
+
+	// unix 时间戳(秒)字段, 如 deleted_at INT NOT NULL DEFAULT 0: 0 表示未删除, 删除时写入 Clock() 的时间戳
+	SoftDeleteUnix
+
+	// 标记字段, 如 is_deleted TINYINT NOT NULL DEFAULT 0: 0 表示未删除, 删除时写入 1
+	SoftDeleteFlag
+)
+
+type softDeleteColumn struct {
+	column     string
+	kind       SoftDeleteKind
+	updateTime []*fieldMeta // 删除与恢复时一并更新的 autoUpdateTime 字段, 只有 SetSoftDeleteModel 注册时才有
+}
+
+type softDeleteConfig struct {
+	mu     sync.RWMutex
+	tables map[string]softDeleteColumn
+}
+
+// 注册软删除的表, 由该 Sql 开启的事务使用同样的设置.
+// 注册之后 Delete 只标记 column 而不删除数据, 见 Sql.Delete, Sql.HardDelete 与 Sql.Restore.
+// 分表时 table 为逻辑表名. 没有 struct 定义, 删除与恢复时不会更新 autoUpdateTime 字段, 需要时请使用 SetSoftDeleteModel
+func (this *Sql) SetSoftDelete(table string, column string, kind SoftDeleteKind) {
+	this.setSoftDelete(table, softDeleteColumn{column: column, kind: kind})
+}
+
+func (this *Sql) setSoftDelete(table string, sd softDeleteColumn) {
+
+	if this.softDelete == nil {
+		this.softDelete = new(softDeleteConfig)
+	}
+
+	this.softDelete.mu.Lock()
+	defer this.softDelete.mu.Unlock()
+
+	if this.softDelete.tables == nil {
+		this.softDelete.tables = make(map[string]softDeleteColumn)
+	}
+
+	this.softDelete.tables[table] = sd
+}
+
+// 按 struct 中 db:"deleted_at,softDelete" 标记的字段注册软删除的表.
+// 标记方式由字段类型决定: time.Time, *time.Time 与 sql.NullTime 为 SoftDeleteTime,
+// bool 与整数为 SoftDeleteFlag, 整数字段使用 db:"deleted_at,softDelete=unix" 时为 SoftDeleteUnix.
+// 与 Update 一样, 删除与恢复时 autoUpdateTime 字段写入当前时间
+func (this *Sql) SetSoftDeleteModel(table string, model interface{}) error {
+
+	t := reflect.TypeOf(model)
+
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == nil || t.Kind() != reflect.Struct {
+		return &ReflectError{s: "soft delete model is non-struct"}
+	}
+
+	meta := getStructMeta(t)
+	f := meta.softDelete
+
+	if f == nil {
+		return &ReflectError{s: "soft delete model has no softDelete field:" + t.String()}
+	}
+
+	sd := softDeleteColumn{column: f.column, kind: f.softDelete}
+
+	for _, uf := range meta.fields {
+		if uf.updateTime {
+			sd.updateTime = append(sd.updateTime, uf)
+		}
+	}
+
+	this.setSoftDelete(table, sd)
+	return nil
+}
+
+// 软删除字段的标记方式, 见 Sql.SetSoftDeleteModel
+func softDeleteKindOf(ft reflect.Type, opt string) SoftDeleteKind {
+
+	if ft == timeType || ft == nullTimeType || (ft.Kind() == reflect.Ptr && ft.Elem() == timeType) {
+		return SoftDeleteTime
+	}
+
+	if opt == "unix" {
+		return SoftDeleteUnix
+	}
+
+	return SoftDeleteFlag
+}
+
+// 复制一份设置, 之后的修改互不影响
+func (this *softDeleteConfig) clone() *softDeleteConfig {
+
+	if this == nil {
+		return nil
+	}
+
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	ret := &softDeleteConfig{tables: make(map[string]softDeleteColumn, len(this.tables))}
+
+	for table, sd := range this.tables {
+		ret.tables[table] = sd
+	}
+
+	return ret
+}
+
+func (this *Sql) softDeleteOf(table string) (softDeleteColumn, bool) {
+
+	if this.softDelete == nil {
+		return softDeleteColumn{}, false
+	}
+
+	this.softDelete.mu.RLock()
+	defer this.softDelete.mu.RUnlock()
+
+	sd, ok := this.softDelete.tables[table]
+	return sd, ok
+}
+
+// 未删除的条件, 如 `deleted_at` IS NULL
+func (this softDeleteColumn) alive() string {
+
+	if this.kind == SoftDeleteTime {
+		return fmt.Sprintf("`%s` IS NULL", this.column)
+	}
+
+	return fmt.Sprintf("`%s`=0", this.column)
+}
+
+// 已删除的条件, 如 `deleted_at` IS NOT NULL
+func (this softDeleteColumn) deleted() string {
+
+	if this.kind == SoftDeleteTime {
+		return fmt.Sprintf("`%s` IS NOT NULL", this.column)
+	}
+
+	return fmt.Sprintf("`%s`<>0", this.column)
+}
+
+// 删除时写入的值
+func (this softDeleteColumn) mark(now time.Time) interface{} {
+
+	switch this.kind {
+	case SoftDeleteTime:
+		// 与其他写入的时间一样按 TimeLocation 格式化
+		return ToStr(now)
+	case SoftDeleteUnix:
+		return now.Unix()
+	}
+
+	return 1
+}
+
+// 恢复时写入的值
+func (this softDeleteColumn) unmark() interface{} {
+
+	if this.kind == SoftDeleteTime {
+		return nil
+	}
+
+	return 0
+}
+
+// 删除与恢复的 SET 子句, 软删除字段写入 v, autoUpdateTime 字段写入 now
+func (this softDeleteColumn) set(v interface{}, now time.Time) (string, []interface{}, error) {
+
+	set := fmt.Sprintf("`%s`=?", this.column)
+	args := []interface{}{v}
+
+	for _, f := range this.updateTime {
+
+		tv, ok := timeValueOf(f.typ, now)
+
+		if !ok {
+			return "", nil, &ReflectError{s: "unsupported auto time field type:" + f.typ.String()}
+		}
+
+		dv, err := f.encode(tv)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		set += fmt.Sprintf(",`%s`=?", f.column)
+		args = append(args, dv)
+	}
+
+	return set, args, nil
+}
+
+// 查询未删除数据的条件, 用于自行拼写的 SQL:
+//
+//	if cond, ok := client.NotDeleted("order"); ok {
+//		where = where + " AND " + cond
+//	}
+//
+// table 没有注册软删除时 ok 为 false
+func (this *Sql) NotDeleted(table string) (string, bool) {
+
+	if sd, ok := this.softDeleteOf(table); ok {
+		return sd.alive(), true
+	}
+
+	return "", false
+}
+
+// 物理删除数据, 不受软删除设置的影响
+func (this *Sql) HardDelete(table string, whereFmt string, whereValue ...interface{}) *ClientExecResult {
+	return this.HardDeleteContext(context.Background(), table, whereFmt, whereValue...)
+}
+
+// HardDeleteContext 同 HardDelete, 使用 ctx 控制语句的取消与超时
+func (this *Sql) HardDeleteContext(ctx context.Context, table string, whereFmt string, whereValue ...interface{}) *ClientExecResult {
+
+	table, err := this.routeTable(table, nil)
+
+	if err != nil {
+		return &ClientExecResult{Err: err}
+	}
+
+	sql := fmt.Sprintf("DELETE FROM `%s` WHERE %s", table, whereFmt)
+	return this.execContext(ctx, sql, whereValue...)
+}
+
+// 软删除: UPDATE `table` SET `deleted_at`=?[,`updated_at`=?] WHERE (whereFmt) AND `deleted_at` IS NULL.
+// 已删除的行不会被再次标记, 删除时间保持第一次删除的时间
+func (this *Sql) softDeleteContext(ctx context.Context, sd softDeleteColumn, table string, whereFmt string, whereValue ...interface{}) *ClientExecResult {
+
+	table, err := this.routeTable(table, nil)
+
+	if err != nil {
+		return &ClientExecResult{Err: err}
+	}
+
+	now := Clock()
+	set, args, err := sd.set(sd.mark(now), now)
+
+	if err != nil {
+		return &ClientExecResult{Err: err}
+	}
+
+	sql := fmt.Sprintf("UPDATE `%s` SET %s WHERE (%s) AND %s", table, set, whereFmt, sd.alive())
+	return this.execContext(ctx, sql, append(args, whereValue...)...)
+}
+
+// 恢复软删除的数据.
+// SQL语句为: UPDATE `table` SET `deleted_at`=NULL WHERE (whereFmt) AND `deleted_at` IS NOT NULL
+func (this *Sql) Restore(table string, whereFmt string, whereValue ...interface{}) *ClientExecResult {
+	return this.RestoreContext(context.Background(), table, whereFmt, whereValue...)
+}
+
+// RestoreContext 同 Restore, 使用 ctx 控制语句的取消与超时
+func (this *Sql) RestoreContext(ctx context.Context, table string, whereFmt string, whereValue ...interface{}) *ClientExecResult {
+
+	sd, ok := this.softDeleteOf(table)
+
+	if !ok {
+		return &ClientExecResult{Err: &SQLError{s: "table `" + table + "` is not registered for soft delete"}}
+	}
+
+	table, err := this.routeTable(table, nil)
+
+	if err != nil {
+		return &ClientExecResult{Err: err}
+	}
+
+	set, args, err := sd.set(sd.unmark(), Clock())
+
+	if err != nil {
+		return &ClientExecResult{Err: err}
+	}
+
+	sql := fmt.Sprintf("UPDATE `%s` SET %s WHERE (%s) AND %s", table, set, whereFmt, sd.deleted())
+	return this.execContext(ctx, sql, append(args, whereValue...)...)
+}
@@ -0,0 +1,238 @@
+package litedb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// 事务选项
+type TxOptions struct {
+	// 隔离级别, sql.LevelDefault 表示使用数据库的默认设置.
+	// MySQL 支持 READ UNCOMMITTED, READ COMMITTED, REPEATABLE READ, SERIALIZABLE
+	Isolation sql.IsolationLevel
+
+	// 只读事务, 可用于将报表类事务路由到从库
+	ReadOnly bool
+
+	// 使用 START TRANSACTION WITH CONSISTENT SNAPSHOT 开启事务,
+	// 事务开始时即建立一致性读视图. 仅在 REPEATABLE READ 下有效
+	ConsistentSnapshot bool
+}
+
+// Options 返回开启事务时使用的选项, 嵌套事务与外层事务相同
+func (this *Transaction) Options() TxOptions {
+	return this.options
+}
+
+// database/sql 无法直接开启一致性快照事务.
+// 在驱动开启的空事务中再次执行 START TRANSACTION 会隐式提交该空事务, 并在同一个连接上开启新的事务.
+// 驱动设置的隔离级别只对它开启的事务有效, 指定了隔离级别时需要先结束该事务再重新设置
+func (this *Transaction) startConsistentSnapshot(ctx context.Context) error {
+
+	if this.options.Isolation == sql.LevelRepeatableRead {
+		for _, stmt := range []string{"COMMIT", "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ"} {
+			if r := this.ExecContext(ctx, stmt); r.Err != nil {
+				return r.Err
+			}
+		}
+	}
+
+	stmt := "START TRANSACTION WITH CONSISTENT SNAPSHOT"
+
+	if this.options.ReadOnly {
+		stmt += ", READ ONLY"
+	}
+
+	return this.ExecContext(ctx, stmt).Err
+}
+
+// 事务重试策略, 仅在死锁(1213)或等待锁超时(1205)时重试整个事务
+type TxRetryPolicy struct {
+	MaxRetries int                             // 最大重试次数, 0 表示不重试
+	Backoff    func(attempt int) time.Duration // 第 attempt(从1开始) 次重试前的等待时间, nil 表示立即重试
+}
+
+// 指数退避: base, 2*base, 4*base ... 最大不超过 max
+func ExponentialBackoff(base time.Duration, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// 设置 WithTransaction 的重试策略
+func (this *Client) SetTxRetryPolicy(policy TxRetryPolicy) {
+	this.txRetry = policy
+}
+
+// WithTransaction 在事务中执行 fn.
+// fn 返回 nil 时提交事务, 返回错误或 panic 时回滚事务(panic 会在回滚后继续抛出).
+// 设置了 TxRetryPolicy 时, 遇到死锁或等待锁超时会重新开启事务并再次执行 fn,
+// 因此 fn 需要是可以重复执行的.
+//
+//	err := client.WithTransaction(ctx, nil, func(tx *litedb.Transaction) error {
+//		if r := tx.Insert("order", order); r.Err != nil {
+//			return r.Err
+//		}
+//		return tx.Update("stock", stock, "id = ?", stock.Id).Err
+//	})
+func (this *Client) WithTransaction(ctx context.Context, opts *sql.TxOptions, fn func(tx *Transaction) error) error {
+
+	for attempt := 0; ; attempt++ {
+
+		err := this.runTransaction(ctx, opts, fn)
+
+		if err == nil || attempt >= this.txRetry.MaxRetries || !(IsDeadlock(err) || IsLockWaitTimeout(err)) {
+			return err
+		}
+
+		if Debug {
+			log.Println("[Litedb Debug] retry transaction:", attempt+1, err)
+		}
+
+		if this.txRetry.Backoff != nil {
+			timer := time.NewTimer(this.txRetry.Backoff(attempt + 1))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+func (this *Client) runTransaction(ctx context.Context, opts *sql.TxOptions, fn func(tx *Transaction) error) error {
+
+	tx, err := this.BeginTx(ctx, opts)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Begin 开启事务.
+// 在 Client 上调用时开启一个新的事务, 在 Transaction 上调用时开启基于保存点的嵌套事务.
+// 因此面向 *Sql 编写的代码既可以单独使用, 也可以在外层事务中使用:
+//
+//	func Transfer(s *litedb.Sql, from, to int64, amount int) error {
+//		tx, err := s.Begin()
+//		if err != nil {
+//			return err
+//		}
+//		...
+//		return tx.Commit()
+//	}
+func (this *Sql) Begin() (*Transaction, error) {
+	return this.BeginTx(context.Background(), nil)
+}
+
+// BeginTx 同 Begin, 嵌套事务会忽略 opts
+func (this *Sql) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Transaction, error) {
+
+	if this.beginTx == nil {
+		return nil, &SQLError{s: "begin transaction is not supported"}
+	}
+
+	return this.beginTx(ctx, opts)
+}
+
+// Begin 开启基于保存点的嵌套事务
+func (this *Transaction) Begin() (*Transaction, error) {
+	return this.beginNested(context.Background(), nil)
+}
+
+func (this *Transaction) beginNested(ctx context.Context, opts *sql.TxOptions) (*Transaction, error) {
+
+	if this.done {
+		return nil, wrapError(sql.ErrTxDone)
+	}
+
+	*this.seq++
+	name := fmt.Sprintf("litedb_sp_%d", *this.seq)
+
+	err := this.intercept(ctx, &QueryInfo{Op: OpBegin, InTransaction: true}, func(ctx context.Context, info *QueryInfo) error {
+		return this.Savepoint(name)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	tran := new(Transaction)
+	tran.tx = this.tx
+	tran.db = this.db
+	tran.seq = this.seq
+	tran.options = this.options
+	tran.router = this.router
+	tran.batch = this.batch
+	tran.softDelete = this.softDelete
+	tran.interceptors = this.interceptors
+	tran.ctx = ctx
+	tran.parent = this
+	tran.savepoint = name
+	tran.init()
+
+	return tran, nil
+}
+
+func (this *Transaction) finishNested(commit bool) error {
+
+	if this.done {
+		return wrapError(sql.ErrTxDone)
+	}
+
+	var err error
+
+	if commit {
+		err = this.ReleaseSavepoint(this.savepoint)
+	} else {
+		err = this.RollbackTo(this.savepoint)
+	}
+
+	this.done = true
+	return err
+}
+
+// Savepoint 创建保存点
+func (this *Transaction) Savepoint(name string) error {
+	return this.Exec("SAVEPOINT " + quoteIdentifier(name)).Err
+}
+
+// RollbackTo 回滚到保存点, 保存点之后的修改会被撤销, 保存点本身仍然保留
+func (this *Transaction) RollbackTo(name string) error {
+	return this.Exec("ROLLBACK TO SAVEPOINT " + quoteIdentifier(name)).Err
+}
+
+// ReleaseSavepoint 释放保存点, 不影响已经执行的修改
+func (this *Transaction) ReleaseSavepoint(name string) error {
+	return this.Exec("RELEASE SAVEPOINT " + quoteIdentifier(name)).Err
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
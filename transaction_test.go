@@ -0,0 +1,454 @@
+package litedb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// 记录事务操作的测试驱动, Exec 的 SQL 或参数中包含 "deadlock" 时返回死锁错误
+type txDriver struct {
+	mu     sync.Mutex
+	events []string
+}
+
+type txConn struct {
+	d *txDriver
+}
+
+func (this *txDriver) record(e string) {
+	this.mu.Lock()
+	this.events = append(this.events, e)
+	this.mu.Unlock()
+}
+
+func (this *txDriver) reset() []string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	events := this.events
+	this.events = nil
+	return events
+}
+
+func (this *txDriver) Open(name string) (driver.Conn, error) { return &txConn{d: this}, nil }
+
+func (this *txConn) Prepare(query string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (this *txConn) Close() error                              { return nil }
+func (this *txConn) Begin() (driver.Tx, error) {
+	this.d.record("begin")
+	return this, nil
+}
+func (this *txConn) Commit() error {
+	this.d.record("commit")
+	return nil
+}
+func (this *txConn) Rollback() error {
+	this.d.record("rollback")
+	return nil
+}
+func (this *txConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	this.d.record(query)
+	if err := this.d.readBulkData(query); err != nil {
+		return nil, err
+	}
+	for _, arg := range append([]driver.Value{query}, args...) {
+		if v, ok := arg.(string); ok && strings.Contains(v, "deadlock") {
+			return nil, &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+		}
+	}
+	return txResult(100), nil
+}
+
+// 带有 deadline 的 ctx 记录为 deadline 事件
+func (this *txConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+
+	if _, ok := ctx.Deadline(); ok {
+		this.d.record("deadline")
+	}
+
+	values := make([]driver.Value, 0, len(args))
+
+	for _, arg := range args {
+		values = append(values, arg.Value)
+	}
+
+	return this.Exec(query, values)
+}
+
+// 每条语句影响一行, LastInsertId 为固定值
+type txResult int64
+
+func (this txResult) LastInsertId() (int64, error) { return int64(this), nil }
+func (this txResult) RowsAffected() (int64, error) { return 1, nil }
+
+var testTxDriver = &txDriver{}
+
+func init() {
+	sql.Register("litedb_tx", testTxDriver)
+}
+
+func newTxClient(t *testing.T) *Client {
+
+	db, err := sql.Open("litedb_tx", "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := new(Client)
+	client.db = db
+	client.init()
+
+	testTxDriver.reset()
+
+	return client
+}
+
+func TestWithTransaction(t *testing.T) {
+
+	client := newTxClient(t)
+	ctx := context.Background()
+
+	err := client.WithTransaction(ctx, nil, func(tx *Transaction) error {
+		return tx.Exec("UPDATE a").Err
+	})
+
+	if err != nil || strings.Join(testTxDriver.reset(), ",") != "begin,UPDATE a,commit" {
+		t.Fatal("commit expected", err)
+	}
+
+	fail := errors.New("fail")
+
+	err = client.WithTransaction(ctx, nil, func(tx *Transaction) error {
+		tx.Exec("UPDATE a")
+		return fail
+	})
+
+	if err != fail || strings.Join(testTxDriver.reset(), ",") != "begin,UPDATE a,rollback" {
+		t.Fatal("rollback expected", err)
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Fatal("panic should be re-thrown", p)
+			}
+		}()
+		client.WithTransaction(ctx, nil, func(tx *Transaction) error {
+			panic("boom")
+		})
+	}()
+
+	if strings.Join(testTxDriver.reset(), ",") != "begin,rollback" {
+		t.Fatal("rollback expected on panic")
+	}
+}
+
+func TestWithTransactionRetry(t *testing.T) {
+
+	client := newTxClient(t)
+	client.SetTxRetryPolicy(TxRetryPolicy{MaxRetries: 2})
+
+	calls := 0
+
+	err := client.WithTransaction(context.Background(), nil, func(tx *Transaction) error {
+		calls++
+		if calls < 3 {
+			return tx.Exec("UPDATE deadlock").Err
+		}
+		return tx.Exec("UPDATE a").Err
+	})
+
+	if err != nil || calls != 3 {
+		t.Fatal("expect success after 2 retries", err, calls)
+	}
+
+	calls = 0
+	testTxDriver.reset()
+
+	err = client.WithTransaction(context.Background(), nil, func(tx *Transaction) error {
+		calls++
+		return tx.Exec("UPDATE deadlock").Err
+	})
+
+	if !IsDeadlock(err) || calls != 3 {
+		t.Fatal("expect deadlock after retries exhausted", err, calls)
+	}
+}
+
+func TestNestedTransaction(t *testing.T) {
+
+	client := newTxClient(t)
+
+	// 面向 *Sql 编写的函数
+	work := func(s *Sql, fail bool) error {
+		tx, err := s.Begin()
+		if err != nil {
+			return err
+		}
+		tx.Exec("UPDATE a")
+		if fail {
+			return tx.Rollback()
+		}
+		return tx.Commit()
+	}
+
+	if err := work(&client.Sql, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(testTxDriver.reset(), ","); got != "begin,UPDATE a,commit" {
+		t.Fatal("unexpected events:", got)
+	}
+
+	tx, err := client.Begin()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := work(&tx.Sql, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := work(&tx.Sql, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "begin," +
+		"SAVEPOINT `litedb_sp_1`,UPDATE a,RELEASE SAVEPOINT `litedb_sp_1`," +
+		"SAVEPOINT `litedb_sp_2`,UPDATE a,ROLLBACK TO SAVEPOINT `litedb_sp_2`," +
+		"commit"
+
+	if got := strings.Join(testTxDriver.reset(), ","); got != expect {
+		t.Fatal("unexpected events:", got)
+	}
+}
+
+func TestConsistentSnapshot(t *testing.T) {
+
+	client := newTxClient(t)
+
+	tx, err := client.BeginWithOptions(context.Background(), TxOptions{ConsistentSnapshot: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nested, err := tx.Begin()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !tx.Options().ConsistentSnapshot || nested.Options() != tx.Options() {
+		t.Fatal("unexpected options:", tx.Options(), nested.Options())
+	}
+
+	nested.Commit()
+	tx.Commit()
+
+	expect := "begin,START TRANSACTION WITH CONSISTENT SNAPSHOT," +
+		"SAVEPOINT `litedb_sp_1`,RELEASE SAVEPOINT `litedb_sp_1`,commit"
+
+	if got := strings.Join(testTxDriver.reset(), ","); got != expect {
+		t.Fatal("unexpected events:", got)
+	}
+
+	if _, err := client.BeginWithOptions(context.Background(), TxOptions{Isolation: sql.LevelReadCommitted, ConsistentSnapshot: true}); err == nil {
+		t.Fatal("consistent snapshot should require REPEATABLE READ")
+	}
+}
+
+func TestContextReachesDriver(t *testing.T) {
+
+	client := newTxClient(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if r := client.InsertContext(ctx, "person", &sqlPerson{Id: 1}); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+
+	if events := testTxDriver.reset(); len(events) != 2 || events[0] != "deadline" {
+		t.Fatal("ctx should reach the driver:", events)
+	}
+
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+
+	if r := client.DeleteContext(canceled, "person", "id = ?", 1); !errors.Is(r.Err, context.Canceled) {
+		t.Fatal("canceled ctx should stop the statement, got", r.Err)
+	}
+
+	if _, err := QueryScalarContext[int](canceled, &client.Sql, "SELECT 1"); !errors.Is(err, context.Canceled) {
+		t.Fatal("canceled ctx should stop the query, got", err)
+	}
+
+	if events := testTxDriver.reset(); len(events) != 0 {
+		t.Fatal("canceled statements should not reach the driver:", events)
+	}
+
+	s, sqls, _ := recordSql()
+
+	if r := s.DeleteContext(ctx, "person", "id = ?", 1); r.Err == nil || len(*sqls) != 0 {
+		t.Fatal("Sql without ExecContext should not drop a cancelable ctx")
+	}
+
+	if r := s.DeleteContext(context.Background(), "person", "id = ?", 1); r.Err != nil || len(*sqls) != 1 {
+		t.Fatal("Sql without ExecContext should fall back to Exec", r.Err)
+	}
+
+	if _, err := QueryScalarContext[int](ctx, benchSql(), "SELECT 1"); err == nil {
+		t.Fatal("Sql without QueryContext should not drop a cancelable ctx")
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+
+	client := newTxClient(t)
+	ctx := context.Background()
+
+	var infos []QueryInfo
+	blocked := errors.New("blocked")
+
+	client.Use(func(ctx context.Context, info *QueryInfo, next Invoker) error {
+		err := next(ctx, info)
+		infos = append(infos, *info)
+		return err
+	}, func(ctx context.Context, info *QueryInfo, next Invoker) error {
+		if strings.Contains(info.SQL, "blocked") {
+			return blocked
+		}
+		info.SQL = "/* traced */ " + info.SQL
+		return next(ctx, info)
+	})
+
+	if r := client.Exec("UPDATE a"); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+
+	if r := client.Exec("UPDATE blocked"); r.Err != blocked {
+		t.Fatal("interceptor error expected", r.Err)
+	}
+
+	tx, err := client.BeginTx(ctx, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var inner []QueryOp
+
+	tx.Use(func(ctx context.Context, info *QueryInfo, next Invoker) error {
+		inner = append(inner, info.Op)
+		return next(ctx, info)
+	})
+
+	nested, _ := tx.Begin()
+	nested.Exec("UPDATE b")
+	nested.Rollback()
+	tx.Commit()
+
+	events := strings.Join(testTxDriver.reset(), ",")
+	expect := "/* traced */ UPDATE a,begin,/* traced */ SAVEPOINT `litedb_sp_1`,/* traced */ UPDATE b," +
+		"/* traced */ ROLLBACK TO SAVEPOINT `litedb_sp_1`,commit"
+
+	if events != expect {
+		t.Fatalf("events = %s, want %s", events, expect)
+	}
+
+	ops := make([]string, 0, len(infos))
+
+	for _, info := range infos {
+		ops = append(ops, string(info.Op))
+	}
+
+	if got := strings.Join(ops, ","); got != "exec,exec,begin,exec,begin,exec,exec,rollback,commit" {
+		t.Fatal("unexpected ops:", got)
+	}
+
+	if infos[0].RowsAffected != 1 || infos[0].SQL != "/* traced */ UPDATE a" || infos[0].InTransaction || infos[0].Duration <= 0 {
+		t.Fatalf("unexpected exec info: %+v", infos[0])
+	}
+
+	if infos[1].Err != nil || infos[6].InTransaction != true {
+		t.Fatalf("unexpected info: %+v %+v", infos[1], infos[6])
+	}
+
+	if got := fmt.Sprint(inner); got != "[begin exec exec rollback exec commit]" {
+		t.Fatal("transaction interceptor should only see the transaction:", got)
+	}
+
+	if len(client.interceptors) != 2 {
+		t.Fatal("transaction Use should not change the client")
+	}
+}
+
+func TestBatchTransactionRollbackIds(t *testing.T) {
+
+	client := newTxClient(t)
+	client.SetBatchOptions(BatchOptions{MaxRows: 2, MaxBytes: 1 << 20, Transaction: true})
+
+	rows := []autoPerson{{Name: "a"}, {Name: "b"}, {Name: "deadlock"}}
+
+	if r := client.BatchInsert("person", rows); r.Err == nil {
+		t.Fatal("second chunk should fail")
+	}
+
+	if rows[0].Id != 0 || rows[1].Id != 0 || rows[2].Id != 0 {
+		t.Fatalf("ids of rolled back rows should be reset: %+v", rows)
+	}
+
+	if events := strings.Join(testTxDriver.reset(), ","); !strings.HasPrefix(events, "begin,") || !strings.HasSuffix(events, ",rollback") {
+		t.Fatal("batch should be rolled back:", events)
+	}
+
+	rows[2].Name = "c"
+
+	if r := client.BatchInsert("person", rows); r.Err != nil || rows[0].Id != 100 || rows[2].Id != 100 {
+		t.Fatalf("ids should be populated after commit: %v %+v", r.Err, rows)
+	}
+}
+
+func TestInterceptorSkipsNext(t *testing.T) {
+
+	client := newTxClient(t)
+
+	client.Use(func(ctx context.Context, info *QueryInfo, next Invoker) error {
+		return nil
+	})
+
+	if r := client.Exec("UPDATE a"); r.Err == nil || r.Result != nil {
+		t.Fatal("skipped exec should fail", r.Err)
+	}
+
+	if r := client.Query("SELECT 1"); r.Err == nil || r.Rows != nil {
+		t.Fatal("skipped query should fail", r.Err)
+	}
+
+	if _, err := client.Query("SELECT 1").ToMap(); err == nil {
+		t.Fatal("skipped query should fail ToMap")
+	}
+
+	if tx, err := client.Begin(); err == nil || tx != nil {
+		t.Fatal("skipped begin should fail", err)
+	}
+
+	if events := testTxDriver.reset(); len(events) != 0 {
+		t.Fatalf("skipped operations should not reach the driver: %v", events)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"time"
 )
 
 // StrTo is the target string
@@ -145,6 +146,8 @@ func ToStr(value interface{}, args ...int) (s string) {
 		s = v
 	case []byte:
 		s = string(v)
+	case time.Time:
+		s = v.In(TimeLocation).Format(timeFormat)
 	default:
 		s = fmt.Sprintf("%v", v)
 	}
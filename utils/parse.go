@@ -153,3 +153,18 @@ func ParseWhereMap(wheres interface{}) (string, []interface{}) {
 
 	return where, valList
 }
+
+// 同 ParseWhereMap, table 通过 SetSoftDelete 注册了软删除时追加未删除的条件, 用于只查询未删除数据的场景:
+//
+//	where, args := utils.ParseWhereMapNotDeleted(&client.Sql, "order", wheres)
+//	client.Query("SELECT * FROM `order` WHERE "+where, args...)
+func ParseWhereMapNotDeleted(s *litedb.Sql, table string, wheres interface{}) (string, []interface{}) {
+
+	where, valList := ParseWhereMap(wheres)
+
+	if cond, ok := s.NotDeleted(table); ok {
+		where = where + " AND " + cond
+	}
+
+	return where, valList
+}
@@ -3,6 +3,8 @@ package utils
 import (
 	"testing"
 	"encoding/json"
+
+	"github.com/weixinhost/litedb"
 )
 
 func TestBasic(t *testing.T) {
@@ -85,7 +87,25 @@ func TestNil(t *testing.T) {
 	t.Error(ParseWhereMap(nil))
 }
 
+func TestParseWhereMapNotDeleted(t *testing.T) {
+
+	s := new(litedb.Sql)
+	s.SetSoftDelete("order", "is_deleted", litedb.SoftDeleteFlag)
 
+	where := map[string]interface{}{"user_id": 7}
+	plain, _ := ParseWhereMap(where)
 
+	got, args := ParseWhereMapNotDeleted(s, "order", where)
 
+	if got != plain+" AND `is_deleted`=0" || len(args) != 1 || args[0] != "7" {
+		t.Fatalf("unexpected where for soft deleted table: %q %v", got, args)
+	}
 
+	if got, _ := ParseWhereMapNotDeleted(s, "user", where); got != plain {
+		t.Fatalf("table without soft delete should not be changed: %q", got)
+	}
+
+	if got, _ := ParseWhereMapNotDeleted(s, "order", nil); got != "1  AND `is_deleted`=0" {
+		t.Fatalf("unexpected where without conditions: %q", got)
+	}
+}
@@ -0,0 +1,475 @@
+package litedb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// 写入以及读取 time.Time 时使用的时区.
+// 写入时会先转换到该时区再格式化, 读取时按该时区解析
+var TimeLocation *time.Location = time.Local
+
+// 填充 autoCreateTime 与 autoUpdateTime 字段时使用的时钟, 测试中可以替换为返回固定时间的函数
+var Clock func() time.Time = time.Now
+
+// 为 true 时 ToStruct 与 FirstToStruct 等按字符串映射的方法在字段类型转换失败
+// (如 "" 或 "1.50" 映射到整数字段, BIT(1) 映射到 bool 字段)时返回错误.
+// 默认为 false, 与之前的版本一致: 忽略转换失败的字段, 字段保持原值
+var StrictDecode bool = false
+
+// 写入数据库时 time.Time 的格式
+const timeFormat = "2006-01-02 15:04:05.999999"
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	nullTimeType = reflect.TypeOf(sql.NullTime{})
+	scannerType  = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	valuerType   = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+)
+
+// 解析 MySQL 返回的 DATE/DATETIME/TIMESTAMP 字符串.
+// 当 DNS 中设置了 parseTime=true 时, 驱动返回的 time.Time 会被 database/sql 格式化为 RFC3339
+func parseTime(s string) (time.Time, error) {
+
+	if len(s) < 1 || strings.HasPrefix(s, "0000-00-00") {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999", "2006-01-02", time.RFC3339Nano} {
+		if t, err := time.ParseInLocation(layout, s, TimeLocation); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, &ReflectError{s: "unsuported time format:" + s}
+}
+
+// 将数据库中的值写入struct字段.
+// raw 为 nil 表示 NULL: 指针字段保持 nil, sql.Scanner 收到 nil, 其余字段置为零值
+type fieldDecoder func(fv reflect.Value, raw *string) error
+
+// 将struct字段转换为可以交给驱动的值
+type fieldEncoder func(fv reflect.Value) (interface{}, error)
+
+func hasMethod(t reflect.Type, name string) bool {
+	_, ok := t.MethodByName(name)
+	return ok
+}
+
+// 根据字段类型选择解码方式, 结果会缓存在 structMeta 中
+func decoderOf(ft reflect.Type) fieldDecoder {
+
+	scanner := ft.Kind() != reflect.Ptr && reflect.PtrTo(ft).Implements(scannerType)
+
+	var decode fieldDecoder
+
+	switch {
+
+	case ft.Kind() == reflect.Ptr && !hasMethod(ft, "UnmarshalDB"):
+		{
+			elemDecode := decoderOf(ft.Elem())
+			decode = func(fv reflect.Value, raw *string) error {
+				elem := reflect.New(ft.Elem())
+				if err := elemDecode(elem.Elem(), raw); err != nil {
+					return err
+				}
+				fv.Set(elem)
+				return nil
+			}
+		}
+
+	case ft == timeType || ft == nullTimeType:
+		{
+			decode = func(fv reflect.Value, raw *string) error {
+				t, err := parseTime(*raw)
+				if err != nil {
+					return err
+				}
+				if ft == timeType {
+					fv.Set(reflect.ValueOf(t))
+				} else {
+					fv.Set(reflect.ValueOf(sql.NullTime{Time: t, Valid: true}))
+				}
+				return nil
+			}
+		}
+
+	case scanner:
+		{
+			decode = func(fv reflect.Value, raw *string) error {
+				if err := fv.Addr().Interface().(sql.Scanner).Scan([]byte(*raw)); err != nil {
+					return &ReflectError{s: "scan error:" + err.Error(), err: err}
+				}
+				return nil
+			}
+		}
+
+	default:
+		decode = func(fv reflect.Value, raw *string) error {
+			return decodeKind(fv, raw)
+		}
+	}
+
+	return func(fv reflect.Value, raw *string) error {
+
+		if raw != nil {
+			return decode(fv, raw)
+		}
+
+		if scanner {
+			if err := fv.Addr().Interface().(sql.Scanner).Scan(nil); err != nil {
+				return &ReflectError{s: "scan error:" + err.Error(), err: err}
+			}
+			return nil
+		}
+
+		fv.Set(reflect.Zero(ft))
+		return nil
+	}
+}
+
+// 基础类型的转换错误, 只有 StrictDecode 时返回
+func parseError(err error) error {
+
+	if !StrictDecode {
+		return nil
+	}
+
+	return &ReflectError{s: "parse error:" + err.Error(), err: err}
+}
+
+// 基础类型以及 UnmarshalDB 的解码
+func decodeKind(fv reflect.Value, raw *string) error {
+
+	ft := fv.Type()
+
+	var s StrTo
+	s.Set(*raw)
+
+	var de error = nil
+
+	switch filev := ft.Kind(); filev {
+
+	case reflect.Uint8:
+		{
+			dv, err := s.Uint8()
+			if err != nil {
+				de = parseError(err)
+				break
+			}
+			fv.SetUint(uint64(dv))
+			break
+		}
+	case reflect.Uint16:
+		{
+			dv, err := s.Uint16()
+			if err != nil {
+				de = parseError(err)
+				break
+			}
+			fv.SetUint(uint64(dv))
+			break
+		}
+	case reflect.Uint32:
+		{
+			dv, err := s.Uint32()
+			if err != nil {
+				de = parseError(err)
+				break
+			}
+			fv.SetUint(uint64(dv))
+			break
+		}
+	case reflect.Uint64:
+		{
+			dv, err := s.Uint64()
+			if err != nil {
+				de = parseError(err)
+				break
+			}
+			fv.SetUint(uint64(dv))
+			break
+		}
+	case reflect.Uint:
+		{
+			dv, err := s.Uint()
+			if err != nil {
+				de = parseError(err)
+				break
+			}
+			fv.SetUint(uint64(dv))
+			break
+		}
+
+	case reflect.Int8:
+		{
+			dv, err := s.Int8()
+			if err != nil {
+				de = parseError(err)
+				break
+			}
+			fv.SetInt(int64(dv))
+			break
+		}
+	case reflect.Int16:
+		{
+			dv, err := s.Int16()
+			if err != nil {
+				de = parseError(err)
+				break
+			}
+			fv.SetInt(int64(dv))
+			break
+		}
+	case reflect.Int32:
+		{
+			dv, err := s.Int32()
+			if err != nil {
+				de = parseError(err)
+				break
+			}
+			fv.SetInt(int64(dv))
+			break
+		}
+	case reflect.Int64:
+		{
+			dv, err := s.Int64()
+			if err != nil {
+				de = parseError(err)
+				break
+			}
+			fv.SetInt(int64(dv))
+			break
+		}
+	case reflect.Int:
+		{
+			dv, err := s.Int()
+			if err != nil {
+				de = parseError(err)
+				break
+			}
+			fv.SetInt(int64(dv))
+			break
+		}
+	case reflect.Float32:
+		{
+			dv, err := s.Float32()
+			if err != nil {
+				de = parseError(err)
+				break
+			}
+			fv.SetFloat(float64(dv))
+			break
+		}
+	case reflect.Float64:
+		{
+			dv, err := s.Float64()
+			if err != nil {
+				de = parseError(err)
+				break
+			}
+			fv.SetFloat(float64(dv))
+			break
+		}
+
+	case reflect.String:
+		{
+			fv.SetString(s.String())
+			break
+		}
+	case reflect.Bool:
+		{
+			dv, err := s.Bool()
+			if err != nil {
+				de = parseError(err)
+				break
+			}
+			fv.SetBool(dv)
+		}
+
+	default:
+		{
+
+			m := fv.MethodByName("UnmarshalDB")
+
+			if m.IsValid() {
+				if len(s.String()) > 0 {
+					var setEle reflect.Value
+					if ft.Kind() == reflect.Ptr {
+						setEle = reflect.New(ft.Elem())
+					} else {
+						setEle = reflect.New(ft)
+					}
+
+					nm := setEle.MethodByName("UnmarshalDB")
+
+					vals := nm.Call([]reflect.Value{
+						reflect.ValueOf([]byte(s.String())),
+					})
+
+					if len(vals) > 0 {
+						errVal := vals[0]
+						if !errVal.IsNil() {
+							de = &ReflectError{s: "marshal error:" + errVal.Interface().(error).Error(), err: errVal.Interface().(error)}
+						} else {
+							fv.Set(setEle)
+						}
+					}
+				}
+
+			} else if filev == reflect.Slice && ft.Elem().Kind() == reflect.Uint8 {
+				fv.SetBytes([]byte(*raw))
+			} else {
+				de = &ReflectError{s: "unsuported type:" + filev.String()}
+			}
+		}
+	}
+
+	return de
+}
+
+// 根据字段类型选择编码方式, 结果会缓存在 structMeta 中.
+// nil 指针转换为 NULL, MarshalDB 优先于 driver.Valuer
+func encoderOf(ft reflect.Type) fieldEncoder {
+
+	if m, ok := ft.MethodByName("MarshalDB"); ok {
+		return func(fv reflect.Value) (interface{}, error) {
+
+			if fv.Kind() == reflect.Ptr && fv.IsNil() {
+				return nil, nil
+			}
+
+			vals := fv.Method(m.Index).Call([]reflect.Value{})
+			if len(vals) > 1 {
+				dataVal := vals[0]
+				errVal := vals[1]
+				if errVal.IsNil() && dataVal.CanInterface() {
+					data := dataVal.Interface().([]byte)
+					return string(data), nil
+				}
+				return nil, &ReflectError{s: "MarshalDB error:" + errVal.Interface().(error).Error(), err: errVal.Interface().(error)}
+			}
+			return "", nil
+		}
+	}
+
+	if ft.Implements(valuerType) {
+		return func(fv reflect.Value) (interface{}, error) {
+			return interfaceToDBValue(fv.Interface())
+		}
+	}
+
+	if ft.Kind() != reflect.Ptr && reflect.PtrTo(ft).Implements(valuerType) {
+		return func(fv reflect.Value) (interface{}, error) {
+			if fv.CanAddr() {
+				return interfaceToDBValue(fv.Addr().Interface())
+			}
+			return interfaceToDBValue(fv.Interface())
+		}
+	}
+
+	if ft.Kind() == reflect.Ptr {
+		elemEncode := encoderOf(ft.Elem())
+		return func(fv reflect.Value) (interface{}, error) {
+			if fv.IsNil() {
+				return nil, nil
+			}
+			return elemEncode(fv.Elem())
+		}
+	}
+
+	return func(fv reflect.Value) (interface{}, error) {
+		return interfaceToDBValue(fv.Interface())
+	}
+}
+
+// 自动时间字段的值: time.Time, *time.Time, sql.NullTime 或 unix 时间戳(秒)
+func timeValueOf(ft reflect.Type, now time.Time) (reflect.Value, bool) {
+
+	switch {
+	case ft == timeType:
+		return reflect.ValueOf(now), true
+	case ft == nullTimeType:
+		return reflect.ValueOf(sql.NullTime{Time: now, Valid: true}), true
+	case ft.Kind() == reflect.Ptr && ft.Elem() == timeType:
+		return reflect.ValueOf(&now), true
+	}
+
+	switch ft.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return reflect.ValueOf(now.Unix()).Convert(ft), true
+	}
+
+	return reflect.Value{}, false
+}
+
+// db:"name,json" 的字段以 JSON 格式写入, nil 的指针、map、slice 写为 NULL
+func jsonEncoder(fv reflect.Value) (interface{}, error) {
+
+	switch fv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		if fv.IsNil() {
+			return nil, nil
+		}
+	}
+
+	data, err := json.Marshal(fv.Interface())
+
+	if err != nil {
+		return nil, &ReflectError{s: "json marshal error:" + err.Error(), err: err}
+	}
+
+	return string(data), nil
+}
+
+// 与 jsonEncoder 相反, NULL 与空字符串转换为零值
+func jsonDecoder(fv reflect.Value, raw *string) error {
+
+	fv.Set(reflect.Zero(fv.Type()))
+
+	if raw == nil || len(*raw) < 1 {
+		return nil
+	}
+
+	if err := json.Unmarshal([]byte(*raw), fv.Addr().Interface()); err != nil {
+		return &ReflectError{s: "json unmarshal error:" + err.Error(), err: err}
+	}
+
+	return nil
+}
+
+// 将任意值转换为可以交给驱动的值
+func interfaceToDBValue(v interface{}) (interface{}, error) {
+
+	_, isValuer := v.(driver.Valuer)
+
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		if !isValuer {
+			return interfaceToDBValue(rv.Elem().Interface())
+		}
+	}
+
+	if isValuer {
+		dv, err := v.(driver.Valuer).Value()
+		if err != nil {
+			return nil, &ReflectError{s: "Value error:" + err.Error(), err: err}
+		}
+		v = dv
+	}
+
+	switch dv := v.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return dv, nil
+	default:
+		return ToStr(dv), nil
+	}
+}
@@ -0,0 +1,164 @@
+package litedb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 以逗号分隔保存的字符串列表, 同时实现 driver.Valuer 与 sql.Scanner
+type csvTags []string
+
+func (this csvTags) Value() (driver.Value, error) {
+	return strings.Join(this, ","), nil
+}
+
+func (this *csvTags) Scan(src interface{}) error {
+
+	switch v := src.(type) {
+	case nil:
+		*this = nil
+	case []byte:
+		*this = strings.Split(string(v), ",")
+	}
+
+	return nil
+}
+
+type valueRow struct {
+	Id      int64          `db:"id"`
+	Created time.Time      `db:"created"`
+	Score   *int64         `db:"score"`
+	Nick    sql.NullString `db:"nick"`
+	Login   sql.NullTime   `db:"login"`
+	Tags    csvTags        `db:"tags"`
+}
+
+// 按写入数据库时的编码转换, 再按读取时的规则解码, 模拟一次写入与读取
+func roundTrip(t *testing.T, in interface{}, out interface{}) map[string]*string {
+
+	row, err := structToValues(in)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := make(map[string]*string, len(row.values))
+
+	for k, v := range row.values {
+		switch dv := v.(type) {
+		case nil:
+			raw[k] = nil
+		case []byte:
+			s := string(dv)
+			raw[k] = &s
+		case string:
+			raw[k] = &dv
+		default:
+			t.Fatalf("unexpected driver value %T for %s", v, k)
+		}
+	}
+
+	if err := mapToStruct(raw, out); err != nil {
+		t.Fatal(err)
+	}
+
+	return raw
+}
+
+func TestValueRoundTrip(t *testing.T) {
+
+	defer func(loc *time.Location) { TimeLocation = loc }(TimeLocation)
+	TimeLocation = time.FixedZone("UTC+8", 8*3600)
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 123456000, time.UTC)
+	score := int64(-7)
+
+	in := valueRow{
+		Id:      1,
+		Created: created,
+		Score:   &score,
+		Nick:    sql.NullString{String: "nick", Valid: true},
+		Login:   sql.NullTime{Time: created, Valid: true},
+		Tags:    csvTags{"a", "b"},
+	}
+
+	var out valueRow
+	raw := roundTrip(t, in, &out)
+
+	// 写入时转换到 TimeLocation
+	if *raw["created"] != "2024-01-02 11:04:05.123456" {
+		t.Fatal("time should be written in TimeLocation:", *raw["created"])
+	}
+
+	if !out.Created.Equal(created) || out.Created.Location() != TimeLocation {
+		t.Fatal("time round trip failed:", out.Created)
+	}
+
+	if out.Score == nil || *out.Score != score {
+		t.Fatal("*int64 round trip failed:", out.Score)
+	}
+
+	if out.Nick != in.Nick || !out.Login.Valid || !out.Login.Time.Equal(created) {
+		t.Fatalf("sql.Null* round trip failed: %+v", out)
+	}
+
+	if strings.Join(out.Tags, "|") != "a|b" {
+		t.Fatal("driver.Valuer round trip failed:", out.Tags)
+	}
+}
+
+func TestValueRoundTripNull(t *testing.T) {
+
+	score := int64(1)
+
+	out := valueRow{
+		Created: time.Now(),
+		Score:   &score,
+		Nick:    sql.NullString{String: "x", Valid: true},
+		Login:   sql.NullTime{Time: time.Now(), Valid: true},
+		Tags:    csvTags{"x"},
+	}
+
+	raw := map[string]*string{"id": nil, "created": nil, "score": nil, "nick": nil, "login": nil, "tags": nil}
+
+	if err := mapToStruct(raw, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Id != 0 || !out.Created.IsZero() || out.Score != nil || out.Nick.Valid || out.Login.Valid || out.Tags != nil {
+		t.Fatalf("NULL should reset every field: %+v", out)
+	}
+
+	// nil 指针与无效的 sql.Null* 写入 NULL
+	in := valueRow{Tags: csvTags{}}
+	raw = roundTrip(t, in, &out)
+
+	if raw["score"] != nil || raw["nick"] != nil || raw["login"] != nil {
+		t.Fatalf("NULL expected: %v %v %v", raw["score"], raw["nick"], raw["login"])
+	}
+}
+
+func TestDecodeKindParseError(t *testing.T) {
+
+	bad := "12abc"
+	out := valueRow{Id: 7}
+
+	// 默认与之前的版本一致, 忽略转换失败的字段
+	if err := mapToStruct(map[string]*string{"id": &bad}, &out); err != nil || out.Id != 7 {
+		t.Fatal("parse error should be ignored by default", err, out.Id)
+	}
+
+	StrictDecode = true
+	defer func() { StrictDecode = false }()
+
+	if err := mapToStruct(map[string]*string{"id": &bad}, &out); err == nil {
+		t.Fatal("parse error expected")
+	}
+
+	if err := mapToStruct(map[string]*string{"score": &bad}, &out); err == nil {
+		t.Fatal("parse error expected for pointer field")
+	}
+}